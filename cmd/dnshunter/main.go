@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/5amu/dnshunter/pkg/checks"
+	"github.com/5amu/dnshunter/pkg/checks/dnschecks"
+	"github.com/5amu/dnshunter/pkg/defaults"
 	"github.com/5amu/dnshunter/pkg/output"
 	"github.com/5amu/dnshunter/pkg/utils"
 	"github.com/fatih/color"
@@ -42,7 +47,7 @@ func contains(s []string, e string) bool {
 func uniq(strList []string) []string {
 	list := []string{}
 	for _, item := range strList {
-		if contains(list, item) {
+		if !contains(list, item) {
 			list = append(list, item)
 		}
 	}
@@ -50,77 +55,171 @@ func uniq(strList []string) []string {
 }
 
 type options struct {
-	verbose   bool
-	outFile   string
-	domain    string
-	checklist goflags.StringSlice
-	checks    []checks.Check
+	verbose       bool
+	outFile       string
+	domain        string
+	listFile      string
+	threads       int
+	domains       []string
+	checklist     goflags.StringSlice
+	resolvers     goflags.StringSlice
+	resolvConf    string
+	resolver      *utils.Resolver
+	dkimSelectors goflags.StringSlice
+	dkimWordlist  string
+	checkOpts     checks.Options
+	checkIDs      []string
 }
 
-func (opt *options) run() (err error) {
-	color.Magenta(Banner)
+// newChecks returns a fresh set of the selected checks, checks hold the
+// results of a scan so every domain needs its own
+func (opt *options) newChecks() []checks.Check {
+	if len(opt.checkIDs) == 0 {
+		return checks.AllChecks(&opt.checkOpts)
+	}
+	var list []checks.Check
+	for _, id := range opt.checkIDs {
+		list = append(list, checks.NewCheck(id, &opt.checkOpts))
+	}
+	return list
+}
 
-	c := new(dns.Client)
-	gologger.DefaultLogger.SetMaxLevel(levels.LevelVerbose)
+func (opt *options) scan(domain string, resChan chan<- *output.CheckOutput) *output.DomainOutput {
+	out := &output.DomainOutput{Domain: domain}
 
-	var nameservers *utils.Nameservers
-	if nameservers, err = utils.NewNameserversFromDomain(opt.domain); err != nil {
-		return err
+	nameservers, err := utils.NewNameserversFromDomain(domain, opt.resolver)
+	if err != nil {
+		gologger.Error().Label("ERR").Msgf("%v: %v\n", domain, err)
+		out.Error = err.Error()
+		return out
 	}
+	out.Zone = nameservers.Zone
 
-	gologger.Info().Label("INFO").Msgf("scanning domain   : %v\n", opt.domain)
+	gologger.Info().Label("INFO").Msgf("scanning domain   : %v\n", domain)
+	gologger.Info().Label("INFO").Msgf("in DNS zone       : %v\n", nameservers.Zone)
+	if nameservers.Parent != nil {
+		gologger.Info().Label("INFO").Msgf("delegated to      : %v\n", nameservers.Parent.Names())
+	}
 	gologger.Info().Label("INFO").Msgf("using nameservers : %v\n", nameservers.FQDNs)
-	gologger.Info().Label("INFO").Msgf("with IPv4 version : %v\n", nameservers.IPs)
-	gologger.Info().Label("INFO").Msgf("saving output to  : %v\n\n", opt.outFile)
+	gologger.Info().Label("INFO").Msgf("with addresses    : %v\n\n", nameservers.IPs)
+	for fqdn, err := range nameservers.Unresolved {
+		gologger.Warning().Label("WARN").Msgf("skipping nameserver %v: %v\n", fqdn, err)
+	}
 
+	c := new(dns.Client)
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	resChan := make(chan *output.CheckOutput, 1)
-	for _, check := range opt.checks {
+	for _, check := range opt.newChecks() {
 		wg.Add(1)
 		go func(ch checks.Check) {
+			defer wg.Done()
 			err := ch.Init(c)
 			if err != nil {
 				gologger.Error().Label("ERR").Msgf("check init error: %v", err)
 			} else {
-				if err := ch.Start(opt.domain, nameservers); err != nil {
+				if err := ch.Start(domain, nameservers); err != nil {
 					gologger.Error().Label("ERR").Msgf("check failed with error: %v", err)
 				}
 			}
-			resChan <- ch.Results()
-			wg.Done()
+			if r := ch.Results(); r != nil {
+				mu.Lock()
+				out.Checks = append(out.Checks, r)
+				mu.Unlock()
+				resChan <- r
+			}
 		}(check)
 	}
+	wg.Wait()
+	return out
+}
+
+func (opt *options) run() error {
+	color.Magenta(Banner)
+
+	gologger.DefaultLogger.SetMaxLevel(levels.LevelVerbose)
+	gologger.Info().Label("INFO").Msgf("domains to scan   : %v\n", len(opt.domains))
+	gologger.Info().Label("INFO").Msgf("using resolvers   : %v\n", opt.resolver.Servers)
+	gologger.Info().Label("INFO").Msgf("saving output to  : %v\n\n", opt.outFile)
+
+	jobs := make(chan string)
+	go func() {
+		for _, d := range opt.domains {
+			jobs <- d
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	resChan := make(chan *output.CheckOutput)
+	domChan := make(chan *output.DomainOutput)
+	for i := 0; i < opt.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				domChan <- opt.scan(d, resChan)
+			}
+		}()
+	}
 
-	done := make(chan struct{}, 1)
 	go func() {
 		wg.Wait()
-		done <- struct{}{}
+		close(domChan)
 	}()
 
-	var results []*output.CheckOutput
-	for {
+	var results []*output.DomainOutput
+	for domChan != nil {
 		select {
-		case <-done:
-			fmt.Println("")
-			if opt.outFile != "" {
-				if data, err := json.Marshal(results); err != nil {
-					return err
-				} else {
-					if err := os.WriteFile(opt.outFile, data, 0644); err != nil {
-						return err
-					}
-				}
-			}
-			return nil
 		case r := <-resChan:
 			if opt.verbose {
 				r.PrintVerbose()
 			} else {
 				r.PrintSilent()
 			}
-			results = append(results, r)
+		case d, ok := <-domChan:
+			if !ok {
+				domChan = nil
+				continue
+			}
+			results = append(results, d)
 		}
 	}
+
+	// keep the order in which domains have been provided
+	position := make(map[string]int)
+	for i, d := range opt.domains {
+		position[d] = i
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return position[results[i].Domain] < position[results[j].Domain]
+	})
+
+	fmt.Println("")
+	output.PrintSummary(results)
+
+	if opt.outFile != "" {
+		data, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(opt.outFile, data, 0644)
+	}
+	return nil
+}
+
+// readDomains reads one domain per line, empty lines and lines starting
+// with # are ignored
+func readDomains(r io.Reader) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
 }
 
 func argparse() (*options, error) {
@@ -131,8 +230,22 @@ func argparse() (*options, error) {
 	flagSet.SetDescription("Make DNS and BGP assessment easier.")
 
 	flagSet.StringVarP(&opt.domain, "domain", "d", "", "provide domain to assess")
+	flagSet.StringVarP(&opt.listFile, "list", "l", "", "file containing the domains to assess, one per line (- for stdin)")
+	flagSet.IntVarP(&opt.threads, "threads", "t", 5, "number of domains assessed concurrently")
+	flagSet.StringSliceVarP(&opt.resolvers, "resolver", "r", nil, "upstream resolvers to use, with optional port (comma-separated)", goflags.CommaSeparatedStringSliceOptions)
+	flagSet.StringVarP(&opt.resolvConf, "resolv-conf", "rc", "", "use the nameservers listed in a resolv.conf file (e.g. /etc/resolv.conf)")
 	flagSet.StringVarP(&opt.outFile, "outfile", "o", "", "save output in JSON format")
 	flagSet.StringSliceVarP(&opt.checklist, "checklist", "c", []string{"all"}, "list of singular checks to be executed (comma-separated)", goflags.FileCommaSeparatedStringSliceOptions)
+	flagSet.StringVar(&opt.checkOpts.VRPFile, "vrp", "", "validated ROA payloads exported by rpki-client or Routinator (JSON or CSV)")
+	flagSet.StringVar(&opt.checkOpts.IRRServer, "irr-server", defaults.DefaultIRRServer, "whois-style IRR server (host[:port]) queried for route objects")
+	flagSet.StringVar(&opt.checkOpts.RPSLFile, "irr-file", "", "local RPSL dump used for route objects instead of the IRR server")
+	flagSet.IntVar(&opt.checkOpts.AmplificationThreshold, "amp-threshold", defaults.DNSAmplificationThreshold, "answer/request size ratio above which a nameserver is reported as an amplifier")
+	flagSet.DurationVar(&opt.checkOpts.SignatureExpiryWindow, "sig-expiry", defaults.SignatureExpiryWindow, "report DNSSEC signatures expiring within this window (e.g. 7d)")
+	flagSet.IntVar(&opt.checkOpts.ZoneWalkLimit, "walk-limit", defaults.ZoneWalkLimit, "maximum number of names enumerated by walking the NSEC chain")
+	flagSet.StringSliceVar(&opt.dkimSelectors, "dkim-selectors", nil, "known DKIM selectors of the domains, probed along with the built-in catalogue (comma-separated)", goflags.CommaSeparatedStringSliceOptions)
+	flagSet.StringVar(&opt.dkimWordlist, "dkim-wordlist", "", "file of DKIM selectors to probe, one per line")
+	flagSet.IntVar(&opt.checkOpts.DKIMWorkers, "dkim-workers", defaults.DKIMWorkers, "number of DKIM selectors probed concurrently on every nameserver")
+	flagSet.StringVar(&opt.checkOpts.SOAProfile, "soa-profile", dnschecks.DefaultSOAProfile, "SOA timer profile: ripe-203, rfc1912 or the path of a YAML profile")
 	flagSet.BoolVarP(&opt.verbose, "verbose", "v", false, "print more information")
 
 	version := func() func() {
@@ -144,37 +257,108 @@ func argparse() (*options, error) {
 
 	flagSet.SetCustomHelpText(`POSSIBLE CHECKS:
     soa             check SOA record fields for misconfigurations
-    any             check for DNS amplification vulnerability (ANY query)
+    any             check for DNS amplification (ANY, DNSKEY, TXT, RRSIG queries)
     glue            check if record NS provides GLUE records
     zone            check an unauthenticated zone transfer can be performed
-    dnssec          check if DNSSSEC is implemented by nameserver(s)
-    spf             check security of the SPF record
+    delegation      check consistency between parent and child NS records
+    lame            check for lame delegations (unresponsive or non-authoritative NS)
+    ipv6            check that the zone has nameservers reachable over IPv6
+    recursion       check if the nameservers act as open recursive resolvers
+    dnssec          validate the DNSSEC chain of trust from the root
+    dnssec-hygiene  check DNSSEC signature expiry, algorithms and key sizes
+    nsec            check for zone enumeration via NSEC walking and audit NSEC3 parameters
+    cds             check CDS/CDNSKEY records and key rollover consistency
+    spf             evaluate the SPF record and flatten the senders it authorizes
     dmarc           check security of the DMARC record
-    dkim            check security of the DKIM record
+    dkim            find DKIM selectors, attribute them to providers and assess their keys
     geo             check geographic distribution of ASNs
     irr             check validity of IRR for ASNs
-    roa             check route signatures for ASNs
+    roa             check route signatures for ASNs (requires -vrp)
 	`)
 
 	if err := flagSet.Parse(); err != nil {
 		return nil, err
 	}
 
-	if opt.domain == "" {
-		return nil, fmt.Errorf("missing domain! (specify with -d)")
-	} else if len(strings.Split(opt.domain, ".")) != 2 {
-		return nil, fmt.Errorf("please provide a second-level domain\nhttps://en.wikipedia.org/wiki/Second-level_domain")
+	var candidates []string
+	if opt.domain != "" {
+		candidates = append(candidates, opt.domain)
+	}
+	if opt.listFile != "" {
+		in := os.Stdin
+		if opt.listFile != "-" {
+			f, err := os.Open(opt.listFile)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			in = f
+		}
+		list, err := readDomains(in)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, list...)
+	} else if opt.domain == "" {
+		// domains can be piped in without specifying -l
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+			list, err := readDomains(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, list...)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("missing domain! (specify with -d, -l or stdin)")
+	}
+	for _, d := range candidates {
+		domain, err := utils.NormalizeDomain(d)
+		if err != nil {
+			return nil, err
+		}
+		opt.domains = append(opt.domains, domain)
+	}
+	opt.domains = uniq(opt.domains)
+
+	resolvers := []string(opt.resolvers)
+	if opt.resolvConf != "" {
+		conf, err := utils.NewResolverFromConf(opt.resolvConf)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, conf.Servers...)
+	}
+	var err error
+	if opt.resolver, err = utils.NewResolver(uniq(resolvers)); err != nil {
+		return nil, err
+	}
+
+	if opt.threads < 1 {
+		return nil, fmt.Errorf("invalid number of threads: %v", opt.threads)
+	}
+	if _, err := dnschecks.LoadSOAProfile(opt.checkOpts.SOAProfile); err != nil {
+		return nil, err
+	}
+	opt.checkOpts.DKIMSelectors = []string(opt.dkimSelectors)
+	if opt.dkimWordlist != "" {
+		// the wordlist is loaded once and shared by the checks of every domain
+		if opt.checkOpts.DKIMWordlist, err = dnschecks.LoadDKIMSelectors(opt.dkimWordlist); err != nil {
+			return nil, fmt.Errorf("can't load DKIM selectors: %v", err)
+		}
+	}
+	if opt.checkOpts.DKIMWorkers < 1 {
+		return nil, fmt.Errorf("invalid number of DKIM workers: %v", opt.checkOpts.DKIMWorkers)
 	}
 
-	if len(opt.checklist) == 0 || contains(opt.checklist, "all") {
-		opt.checks = checks.AllChecks()
-	} else {
+	if len(opt.checklist) != 0 && !contains(opt.checklist, "all") {
 		for _, c := range uniq(opt.checklist) {
-			new := checks.NewCheck(strings.ToLower(c))
-			if new == nil {
+			id := strings.ToLower(c)
+			if checks.NewCheck(id, &opt.checkOpts) == nil {
 				return nil, fmt.Errorf("invalid check: %v", c)
 			}
-			opt.checks = append(opt.checks, new)
+			opt.checkIDs = append(opt.checkIDs, id)
 		}
 	}
 	return opt, nil
@@ -35,21 +35,24 @@ func (c *GEOCkeck) Start(domain string, nameservers *utils.Nameservers) error {
 
 	locations := map[string]int{}
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
 
-		asn, err := utils.NewASN(nameservers.GetIP(fqdn))
-		if err != nil {
-			continue
-		}
+			asn, err := utils.NewASN(ip)
+			if err != nil {
+				continue
+			}
 
-		nameSplitted := strings.Split(asn.Name, ",")
-		if len(nameSplitted) > 1 {
-			key := strings.ReplaceAll(nameSplitted[1], " ", "")
-			locations[key] += 1
+			nameSplitted := strings.Split(asn.Name, ",")
+			if len(nameSplitted) > 1 {
+				key := strings.ReplaceAll(nameSplitted[1], " ", "")
+				locations[key] += 1
+			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 
 	var totalASN, totalGeo int
@@ -1 +1,168 @@
 package bgpchecks
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+// rpslDumps caches the route objects of the local RPSL dumps, since the
+// same file is used for every scanned domain
+var rpslDumps = struct {
+	sync.Mutex
+	m map[string][]utils.RouteObject
+}{m: make(map[string][]utils.RouteObject)}
+
+func loadRPSL(path string) ([]utils.RouteObject, error) {
+	rpslDumps.Lock()
+	defer rpslDumps.Unlock()
+	if routes, ok := rpslDumps.m[path]; ok {
+		return routes, nil
+	}
+	routes, err := utils.LoadRPSL(path)
+	if err != nil {
+		return nil, err
+	}
+	rpslDumps.m[path] = routes
+	return routes, nil
+}
+
+type IRRCheck struct {
+	// Server is the whois-style IRR server (host or host:port) to query
+	Server string
+	// RPSLFile is a local RPSL dump used instead of the server
+	RPSLFile string
+
+	description []string
+	client      *dns.Client
+	routes      []utils.RouteObject
+	output      *output.CheckOutput
+}
+
+func (c *IRRCheck) Init(client *dns.Client) (err error) {
+	c.client = client
+	c.description = []string{
+		"Every prefix announced in BGP should be registered in an Internet",
+		"Routing Registry with a route/route6 object stating the origin AS.",
+		"Networks build their prefix filters from the IRR, so missing objects,",
+		"objects with a different origin or objects much broader than the",
+		"announcement lead to dropped routes or leave room for hijacks.",
+	}
+	if c.Server == "" {
+		c.Server = defaults.DefaultIRRServer
+	}
+	if c.RPSLFile != "" {
+		c.routes, err = loadRPSL(c.RPSLFile)
+	}
+	return err
+}
+
+func (c *IRRCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "BGP IRR Registration",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
+
+			asn, err := utils.NewASN(ip)
+			if err != nil {
+				msg := fmt.Sprintf("unable to find the origin AS of %v: %v", ip, err)
+				res.Information = append(res.Information, msg)
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+			if asn.Prefix == nil {
+				msg := fmt.Sprintf("unable to find the BGP prefix announcing %v", asn.IP)
+				res.Information = append(res.Information, msg)
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			origin, err := utils.ParseASN(asn.ID)
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("unable to parse the origin AS of %v: %v", ip, err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			routes, err := c.coveringRoutes(asn.Prefix)
+			if err != nil {
+				msg := fmt.Sprintf("unable to get the route objects covering %v: %v", asn.Prefix, err)
+				res.Information = append(res.Information, msg)
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+			res.Information = append(res.Information, fmt.Sprintf("route %v originated by AS%d", asn.Prefix, origin))
+			res.Information = append(res.Information, evaluateRoutes(routes, origin, asn.Prefix, &res.Vulnerable)...)
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *IRRCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+func (c *IRRCheck) coveringRoutes(prefix *net.IPNet) ([]utils.RouteObject, error) {
+	routes := c.routes
+	if c.RPSLFile == "" {
+		var err error
+		if routes, err = utils.QueryIRR(c.Server, prefix); err != nil {
+			return nil, err
+		}
+	}
+	return utils.CoveringRoutes(routes, prefix), nil
+}
+
+// evaluateRoutes compares the announcement with the registered route objects
+// covering it, every kind of problem is reported as a separate finding
+func evaluateRoutes(routes []utils.RouteObject, origin uint32, prefix *net.IPNet, isVuln *bool) []string {
+	var exactMatch bool
+	var broader, mismatched []utils.RouteObject
+	for _, r := range routes {
+		exact := r.Prefix.String() == prefix.String()
+		switch {
+		case exact && r.Origin == origin:
+			exactMatch = true
+		case exact:
+			mismatched = append(mismatched, r)
+		default:
+			broader = append(broader, r)
+		}
+	}
+
+	var msgs []string
+	if exactMatch {
+		msgs = append(msgs, fmt.Sprintf("route object found for %v with origin AS%d", prefix, origin))
+	} else {
+		*isVuln = true
+		msgs = append(msgs, fmt.Sprintf("missing route object: no object registers %v with origin AS%d", prefix, origin))
+	}
+	for _, r := range mismatched {
+		*isVuln = true
+		msgs = append(msgs, fmt.Sprintf("origin mismatch: route object %v does not match origin AS%d", r, origin))
+	}
+	for _, r := range broader {
+		*isVuln = true
+		msg := fmt.Sprintf("overly broad route object: %v registered for announced %v", r, prefix)
+		if r.Origin != origin {
+			msg += fmt.Sprintf(", with an origin different from AS%d", origin)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
@@ -1 +1,114 @@
 package bgpchecks
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+// vrpSets caches the parsed VRP exports, since the same file is used for
+// every scanned domain
+var vrpSets = struct {
+	sync.Mutex
+	m map[string][]utils.VRP
+}{m: make(map[string][]utils.VRP)}
+
+func loadVRPs(path string) ([]utils.VRP, error) {
+	vrpSets.Lock()
+	defer vrpSets.Unlock()
+	if vrps, ok := vrpSets.m[path]; ok {
+		return vrps, nil
+	}
+	vrps, err := utils.LoadVRPs(path)
+	if err != nil {
+		return nil, err
+	}
+	vrpSets.m[path] = vrps
+	return vrps, nil
+}
+
+type ROACheck struct {
+	// VRPFile is the path of the rpki-client or Routinator export (JSON
+	// or CSV) used as the Validated ROA Payload set
+	VRPFile string
+
+	description []string
+	client      *dns.Client
+	vrps        []utils.VRP
+	output      *output.CheckOutput
+}
+
+func (c *ROACheck) Init(client *dns.Client) (err error) {
+	c.client = client
+	c.description = []string{
+		"Route Origin Authorizations (ROA) are RPKI signed objects stating which",
+		"AS is allowed to announce a prefix. Routes toward the nameservers that",
+		"are not covered by a ROA (NotFound) or that fail the origin validation",
+		"(Invalid) can be hijacked or will be dropped by validating networks.",
+		"More info at https://www.rfc-editor.org/rfc/rfc6811",
+	}
+	if c.VRPFile == "" {
+		return fmt.Errorf("no VRP file provided for ROA validation")
+	}
+	c.vrps, err = loadVRPs(c.VRPFile)
+	return err
+}
+
+func (c *ROACheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "BGP ROA Validation",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
+
+			asn, err := utils.NewASN(ip)
+			if err != nil {
+				msg := fmt.Sprintf("unable to find the origin AS of %v: %v", ip, err)
+				res.Information = append(res.Information, msg)
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+			if asn.Prefix == nil {
+				msg := fmt.Sprintf("unable to find the BGP prefix announcing %v", asn.IP)
+				res.Information = append(res.Information, msg)
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			origin, err := utils.ParseASN(asn.ID)
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("unable to parse the origin AS of %v: %v", ip, err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			v := utils.ValidateOrigin(c.vrps, origin, asn.Prefix)
+			if v.State != utils.ROAValid {
+				res.Vulnerable = true
+			}
+			res.Information = append(res.Information, fmt.Sprintf("route %v originated by AS%d: %v", asn.Prefix, origin, v.State))
+			res.Information = append(res.Information, v.Reason)
+			for _, vrp := range v.Covering {
+				msg := fmt.Sprintf("covering ROA: %v maxLength %d AS%d (%v)", vrp.Prefix, vrp.MaxLength, vrp.ASN, vrp.TA)
+				res.Information = append(res.Information, msg)
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *ROACheck) Results() *output.CheckOutput {
+	return c.output
+}
@@ -1,6 +1,8 @@
 package checks
 
 import (
+	"time"
+
 	"github.com/5amu/dnshunter/pkg/checks/bgpchecks"
 	"github.com/5amu/dnshunter/pkg/checks/dnschecks"
 	"github.com/5amu/dnshunter/pkg/output"
@@ -14,6 +16,35 @@ type Check interface {
 	Results() *output.CheckOutput
 }
 
+// Options holds the user provided settings needed by some of the checks
+type Options struct {
+	// VRPFile is the Validated ROA Payload export used by the ROA check
+	VRPFile string
+	// IRRServer is the whois-style server queried by the IRR check
+	IRRServer string
+	// RPSLFile is a local RPSL dump used by the IRR check instead of IRRServer
+	RPSLFile string
+	// SOAProfile is the built-in profile name or the YAML profile used to
+	// evaluate the SOA timers
+	SOAProfile string
+	// AmplificationThreshold is the answer/request size ratio above which
+	// the ANY check reports a nameserver as an amplifier
+	AmplificationThreshold int
+	// SignatureExpiryWindow is how long before their expiration the DNSSEC
+	// signatures are reported
+	SignatureExpiryWindow time.Duration
+	// ZoneWalkLimit is the maximum number of names enumerated by walking
+	// the NSEC chain
+	ZoneWalkLimit int
+	// DKIMSelectors are known DKIM selectors of the assessed domains
+	DKIMSelectors []string
+	// DKIMWordlist are the DKIM selectors loaded from a wordlist, probed
+	// after the built-in catalogue
+	DKIMWordlist []string
+	// DKIMWorkers is the number of DKIM selectors probed concurrently
+	DKIMWorkers int
+}
+
 const (
 	SOA     = "soa"
 	ANY     = "any"
@@ -26,14 +57,21 @@ const (
 	GEO     = "geo"
 	IRR     = "irr"
 	ROA     = "roa"
+	DELEG   = "delegation"
+	LAME    = "lame"
+	IPV6    = "ipv6"
+	RECURSE = "recursion"
+	DNSHYG  = "dnssec-hygiene"
+	NSEC    = "nsec"
+	CDS     = "cds"
 )
 
-func NewCheck(id string) Check {
+func NewCheck(id string, opts *Options) Check {
 	switch id {
 	case SOA:
-		return new(dnschecks.SOACheck)
+		return &dnschecks.SOACheck{Profile: opts.SOAProfile}
 	case ANY:
-		return new(dnschecks.ANYCheck)
+		return &dnschecks.ANYCheck{Threshold: opts.AmplificationThreshold}
 	case GLUE:
 		return new(dnschecks.GLUECheck)
 	case ZONE:
@@ -45,28 +83,60 @@ func NewCheck(id string) Check {
 	case DMARC:
 		return new(dnschecks.DMARCCheck)
 	case DKIM:
-		return new(dnschecks.DKIMCheck)
+		return &dnschecks.DKIMCheck{
+			Selectors: opts.DKIMSelectors,
+			Wordlist:  opts.DKIMWordlist,
+			Workers:   opts.DKIMWorkers,
+		}
 	case GEO:
 		return new(bgpchecks.GEOCkeck)
 	case IRR:
-		return nil
+		return &bgpchecks.IRRCheck{Server: opts.IRRServer, RPSLFile: opts.RPSLFile}
+	case DELEG:
+		return new(dnschecks.DelegationCheck)
+	case LAME:
+		return new(dnschecks.LameCheck)
+	case IPV6:
+		return new(dnschecks.IPv6Check)
+	case DNSHYG:
+		return &dnschecks.DNSSECHygieneCheck{ExpiryWindow: opts.SignatureExpiryWindow}
+	case NSEC:
+		return &dnschecks.ZoneWalkCheck{Limit: opts.ZoneWalkLimit}
+	case CDS:
+		return new(dnschecks.CDSCheck)
+	case RECURSE:
+		return new(dnschecks.RecursionCheck)
 	case ROA:
-		return nil
+		return &bgpchecks.ROACheck{VRPFile: opts.VRPFile}
 	default:
 		return nil
 	}
 }
 
-func AllChecks() []Check {
-	return []Check{
-		new(dnschecks.SOACheck),
-		new(dnschecks.ANYCheck),
+func AllChecks(opts *Options) []Check {
+	all := []Check{
+		NewCheck(SOA, opts),
+		NewCheck(ANY, opts),
 		new(dnschecks.GLUECheck),
 		new(dnschecks.AXFRCheck),
 		new(dnschecks.DNSSECCheck),
+		NewCheck(DNSHYG, opts),
+		NewCheck(NSEC, opts),
+		new(dnschecks.CDSCheck),
 		new(dnschecks.SPFCheck),
-		new(dnschecks.DKIMCheck),
+		NewCheck(DKIM, opts),
 		new(dnschecks.DMARCCheck),
+		new(dnschecks.DelegationCheck),
+		new(dnschecks.LameCheck),
+		new(dnschecks.IPv6Check),
+		new(dnschecks.RecursionCheck),
 		new(bgpchecks.GEOCkeck),
+		NewCheck(IRR, opts),
+	}
+	// ROA validation is done offline, so it can only run when a VRP set
+	// has been provided
+	if opts.VRPFile != "" {
+		all = append(all, NewCheck(ROA, opts))
 	}
+	return all
 }
@@ -10,7 +10,21 @@ import (
 	"github.com/miekg/dns"
 )
 
+// amplificationTypes are the query types known to produce large answers,
+// RRSIG and DNSKEY ones grow with the DNSSEC signatures of the zone
+var amplificationTypes = []uint16{
+	dns.TypeANY,
+	dns.TypeDNSKEY,
+	dns.TypeTXT,
+	dns.TypeRRSIG,
+}
+
 type ANYCheck struct {
+	// Threshold is the answer/request size ratio above which a nameserver
+	// is considered an amplifier, defaults.DNSAmplificationThreshold is
+	// used when zero
+	Threshold int
+
 	description []string
 	poc         string
 	client      *dns.Client
@@ -23,10 +37,17 @@ func (c *ANYCheck) Init(client *dns.Client) error {
 		"Answering to ANY queries might get the nameserver to suffer from",
 		"DNS Amplification Attacks, basically ddos attacks based on the fact",
 		"that the answer given by the DNS is much larger that the request",
-		"made by the host. More information on the severity here:",
+		"made by the host. The ratio is measured in bytes over UDP for ANY,",
+		"DNSKEY, TXT and RRSIG queries. More information on the severity here:",
 		"https://www.cisa.gov/uscert/ncas/alerts/TA13-088A",
 	}
-	c.poc = "PoC: dig -t ANY +noall +answer %v @%v"
+	c.poc = "PoC: dig -t %v +dnssec +bufsize=%d +ignore +noall +stats %v @%v"
+	if c.Threshold == 0 {
+		c.Threshold = defaults.DNSAmplificationThreshold
+	}
+	if c.Threshold < 1 {
+		return fmt.Errorf("invalid amplification threshold: %v", c.Threshold)
+	}
 	return nil
 }
 
@@ -39,28 +60,57 @@ func (c *ANYCheck) Start(domain string, nameservers *utils.Nameservers) error {
 	}
 
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
 
-		r, err := utils.MakeQuery(
-			c.client,
-			dns.Fqdn(domain),
-			net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"),
-			dns.TypeANY,
-		)
-		if err != nil {
-			return err
-		}
+			var worst float64
+			var worstType uint16
+			for _, qType := range amplificationTypes {
+				reqSize, respSize, r, err := utils.MeasureUDP(
+					nameservers.Zone,
+					net.JoinHostPort(ip.String(), "53"),
+					qType,
+					defaults.DNSAmplificationBufsize,
+				)
+				if err != nil {
+					msg := fmt.Sprintf("%v query failed: %v", dns.TypeToString[qType], err)
+					res.Information = append(res.Information, msg)
+					continue
+				}
 
-		if len(r.Answer) > defaults.DNSAmplificationThreshold {
-			res.Vulnerable = true
-		}
+				ratio := float64(respSize) / float64(reqSize)
+				msg := fmt.Sprintf("%v: %d bytes answer to a %d bytes query, ratio %.1f", dns.TypeToString[qType], respSize, reqSize, ratio)
+				if r.Truncated {
+					msg += " (truncated)"
+				}
+				res.Information = append(res.Information, msg)
+				if qType == dns.TypeANY && isMinimalANY(r) {
+					res.Information = append(res.Information, "ANY is answered with a minimal HINFO response (RFC 8482)")
+				}
 
-		poc := fmt.Sprintf(c.poc, domain, fqdn)
-		res.Information = append(res.Information, poc)
+				if ratio > worst {
+					worst = ratio
+					worstType = qType
+				}
+			}
+			if worstType == 0 {
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
 
-		c.output.Results = append(c.output.Results, res)
+			msg := fmt.Sprintf("worst amplification: %.1f with %v queries (threshold %d)", worst, dns.TypeToString[worstType], c.Threshold)
+			res.Information = append(res.Information, msg)
+			if worst > float64(c.Threshold) {
+				res.Vulnerable = true
+				poc := fmt.Sprintf(c.poc, dns.TypeToString[worstType], defaults.DNSAmplificationBufsize, nameservers.Zone, ip)
+				res.Information = append(res.Information, poc)
+			}
+
+			c.output.Results = append(c.output.Results, res)
+		}
 	}
 	return nil
 }
@@ -68,3 +118,19 @@ func (c *ANYCheck) Start(domain string, nameservers *utils.Nameservers) error {
 func (c *ANYCheck) Results() *output.CheckOutput {
 	return c.output
 }
+
+// isMinimalANY tells if the ANY query has been answered as recommended by
+// RFC 8482, with a single synthesized HINFO record
+func isMinimalANY(r *dns.Msg) bool {
+	var hinfo bool
+	for _, a := range r.Answer {
+		switch a.(type) {
+		case *dns.HINFO:
+			hinfo = true
+		case *dns.RRSIG:
+		default:
+			return false
+		}
+	}
+	return hinfo
+}
@@ -0,0 +1,234 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+type CDSCheck struct {
+	description []string
+	poc         string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *CDSCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"CDS and CDNSKEY records tell the parent which DS set to publish, so",
+		"that key rollovers can be automated. They must match the DNSKEY set of",
+		"the zone, be signed and be the same on every nameserver, otherwise the",
+		"parent ignores them or installs a DS set that breaks the validation.",
+		"More info at https://www.rfc-editor.org/rfc/rfc7344 and https://www.rfc-editor.org/rfc/rfc8078",
+	}
+	c.poc = "PoC: dig -t %v +dnssec +norecurse %v @%v"
+	return nil
+}
+
+func (c *CDSCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "CDS/CDNSKEY and Key Rollover",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	apex := dns.Fqdn(nameservers.Zone)
+	var parentDS []dns.RR
+	if r, err := nameservers.Resolver.Query(apex, dns.TypeDS); err == nil {
+		parentDS = utils.ExtractRRset(r.Answer, apex, dns.TypeDS)
+	}
+
+	// the sets answered by each server are compared once all of them
+	// answered, a rollover in progress must look the same everywhere
+	cdsSets := make(map[string]bool)
+	keySets := make(map[string]bool)
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+			server := net.JoinHostPort(ip.String(), "53")
+
+			answers := make(map[uint16]*dns.Msg)
+			var err error
+			for _, qType := range []uint16{dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY} {
+				var r *dns.Msg
+				r, err = utils.MakeDNSSECQuery(c.client, apex, server, qType)
+				if err == nil && r.Rcode != dns.RcodeSuccess {
+					err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+				}
+				if err != nil {
+					break
+				}
+				answers[qType] = r
+			}
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			var keys []*dns.DNSKEY
+			keySet := utils.ExtractRRset(answers[dns.TypeDNSKEY].Answer, apex, dns.TypeDNSKEY)
+			for _, rr := range keySet {
+				keys = append(keys, rr.(*dns.DNSKEY))
+			}
+			cds := utils.ExtractRRset(answers[dns.TypeCDS].Answer, apex, dns.TypeCDS)
+			cdnskey := utils.ExtractRRset(answers[dns.TypeCDNSKEY].Answer, apex, dns.TypeCDNSKEY)
+			keySets[rdataSet(keySet)] = true
+			cdsSets[rdataSet(cds)+"|"+rdataSet(cdnskey)] = true
+
+			if len(cds) == 0 && len(cdnskey) == 0 {
+				res.Information = append(res.Information, "no CDS or CDNSKEY record published")
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			var findings []string
+			for qType, rrset := range map[uint16][]dns.RR{dns.TypeCDS: cds, dns.TypeCDNSKEY: cdnskey} {
+				if len(rrset) == 0 {
+					continue
+				}
+				sigs := utils.ExtractRRSIGs(answers[qType].Answer, apex, qType)
+				if err := utils.VerifyRRset(rrset, sigs, keys); err != nil {
+					findings = append(findings, fmt.Sprintf("%v is not validly signed by the zone keys: %v", dns.TypeToString[qType], err))
+				}
+			}
+			sort.Strings(findings)
+
+			if isDeleteRequest(cds, cdnskey) {
+				msg := "CDS/CDNSKEY carry a delete request (algorithm 0), the parent is asked to remove the DS set"
+				if len(parentDS) > 0 {
+					msg += " and the zone will become insecure"
+				}
+				findings = append(findings, msg)
+			} else {
+				findings = append(findings, matchKeys(cds, cdnskey, keys)...)
+				res.Information = append(res.Information, compareParentDS(cds, parentDS))
+			}
+
+			if len(findings) > 0 {
+				res.Vulnerable = true
+				res.Information = append(res.Information, findings...)
+				res.Information = append(res.Information, fmt.Sprintf(c.poc, "CDS", apex, ip))
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+
+	if len(cdsSets) > 1 || len(keySets) > 1 {
+		msg := fmt.Sprintf("the nameservers publish %d different CDS/CDNSKEY sets and %d different DNSKEY sets, the rollover is not consistent", len(cdsSets), len(keySets))
+		for i := range c.output.Results {
+			c.output.Results[i].Vulnerable = true
+			c.output.Results[i].Information = append(c.output.Results[i].Information, msg)
+		}
+	}
+	return nil
+}
+
+func (c *CDSCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// isDeleteRequest tells if the records ask the parent to remove the DS set
+// (RFC 8078 section 4)
+func isDeleteRequest(cds, cdnskey []dns.RR) bool {
+	for _, rr := range cds {
+		if rr.(*dns.CDS).Algorithm == 0 {
+			return true
+		}
+	}
+	for _, rr := range cdnskey {
+		if rr.(*dns.CDNSKEY).Algorithm == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKeys reports the CDS and CDNSKEY records that do not refer to a key of
+// the DNSKEY set, and the CDNSKEY without the corresponding CDS
+func matchKeys(cds, cdnskey []dns.RR, keys []*dns.DNSKEY) []string {
+	var findings []string
+	for _, rr := range cds {
+		ds := &rr.(*dns.CDS).DS
+		var found bool
+		for _, key := range keys {
+			if computed := key.ToDS(ds.DigestType); computed != nil && ds.KeyTag == key.KeyTag() && strings.EqualFold(computed.Digest, ds.Digest) {
+				found = true
+			}
+		}
+		if !found {
+			findings = append(findings, fmt.Sprintf("CDS %d does not match any key of the DNSKEY set", ds.KeyTag))
+		}
+	}
+
+	for _, rr := range cdnskey {
+		key := &rr.(*dns.CDNSKEY).DNSKEY
+		var found bool
+		for _, k := range keys {
+			if k.Flags == key.Flags && k.Algorithm == key.Algorithm && k.PublicKey == key.PublicKey {
+				found = true
+			}
+		}
+		if !found {
+			findings = append(findings, fmt.Sprintf("CDNSKEY %d is not in the DNSKEY set", key.KeyTag()))
+		}
+		if len(cds) == 0 {
+			continue
+		}
+		found = false
+		for _, c := range cds {
+			if c.(*dns.CDS).KeyTag == key.KeyTag() {
+				found = true
+			}
+		}
+		if !found {
+			findings = append(findings, fmt.Sprintf("CDNSKEY %d has no corresponding CDS record", key.KeyTag()))
+		}
+	}
+	return findings
+}
+
+// compareParentDS tells if the parent already publishes the DS set requested
+// by the CDS records
+func compareParentDS(cds, parentDS []dns.RR) string {
+	var requested, published []string
+	for _, rr := range cds {
+		requested = append(requested, fmt.Sprint(rr.(*dns.CDS).KeyTag))
+	}
+	for _, rr := range parentDS {
+		published = append(published, fmt.Sprint(rr.(*dns.DS).KeyTag))
+	}
+	if len(cds) == 0 {
+		return fmt.Sprintf("only CDNSKEY is published, parent DS key tags: %v", published)
+	}
+
+	var ds []dns.RR
+	for _, rr := range cds {
+		ds = append(ds, &rr.(*dns.CDS).DS)
+	}
+	if rdataSet(ds) == rdataSet(parentDS) {
+		return fmt.Sprintf("the parent DS set matches the CDS set (key tags %v)", requested)
+	}
+	return fmt.Sprintf("rollover pending: the parent publishes DS key tags %v, CDS requests key tags %v", published, requested)
+}
+
+// rdataSet returns a canonical representation of the data of an RRset, to
+// compare RRsets regardless of their order, TTL and type
+func rdataSet(rrs []dns.RR) string {
+	var data []string
+	for _, rr := range rrs {
+		data = append(data, strings.ToLower(strings.TrimPrefix(rr.String(), rr.Header().String())))
+	}
+	sort.Strings(data)
+	return strings.Join(data, "\n")
+}
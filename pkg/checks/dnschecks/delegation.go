@@ -0,0 +1,185 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+type DelegationCheck struct {
+	description []string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *DelegationCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"The NS records published in the parent zone (delegation) should be the",
+		"same as the ones served by the nameservers of the zone. Resolvers might",
+		"use either of them, so a mismatch leads to nameservers that never get",
+		"queried or to queries sent to servers that do not serve the zone anymore.",
+		"More info at https://www.rfc-editor.org/rfc/rfc1034#section-4.2.2",
+	}
+	return nil
+}
+
+func (c *DelegationCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "Parent/Child NS Consistency",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	var parentNames []string
+	var parentTTLs []uint32
+	if nameservers.Parent != nil {
+		parentNames = normalizeNames(nameservers.Parent.Names())
+		var rrs []dns.RR
+		for _, ns := range nameservers.Parent.NS {
+			rrs = append(rrs, ns)
+		}
+		parentTTLs = nsTTLs(rrs)
+	}
+
+	childSets := make(map[string]bool)
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				nameservers.Zone,
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeNS,
+			)
+			if err == nil && r.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("%v answered %v", fqdn, dns.RcodeToString[r.Rcode])
+			}
+			if err != nil {
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("unable to get the NS records: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			var childNames []string
+			for _, a := range r.Answer {
+				if t, ok := a.(*dns.NS); ok {
+					childNames = append(childNames, strings.Trim(t.Ns, "."))
+				}
+			}
+			childNames = normalizeNames(childNames)
+			childTTLs := nsTTLs(r.Answer)
+			childSets[strings.Join(childNames, " ")] = true
+			res.Information = append(res.Information, fmt.Sprintf("child NS set: %v", childNames))
+			if len(childTTLs) > 1 {
+				res.Vulnerable = true
+				msg := fmt.Sprintf("mixed TTLs in the child NS RRset: %v (RFC 2181 section 5.2)", formatTTLs(childTTLs))
+				res.Information = append(res.Information, msg)
+			}
+
+			if nameservers.Parent == nil {
+				res.Information = append(res.Information, "parent NS set not available, the parent zone is served by the same nameservers")
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+			res.Information = append(res.Information, fmt.Sprintf("parent NS set: %v", parentNames))
+			if len(parentTTLs) > 1 {
+				res.Vulnerable = true
+				msg := fmt.Sprintf("mixed TTLs in the parent NS RRset: %v (RFC 2181 section 5.2)", formatTTLs(parentTTLs))
+				res.Information = append(res.Information, msg)
+			}
+
+			for _, n := range difference(childNames, parentNames) {
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("extra: %v is served by the zone but not delegated by the parent", n))
+			}
+			for _, n := range difference(parentNames, childNames) {
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("missing: %v is delegated by the parent but not served by the zone", n))
+			}
+			if len(childTTLs) > 0 && len(parentTTLs) > 0 && formatTTLs(childTTLs) != formatTTLs(parentTTLs) {
+				res.Vulnerable = true
+				msg := fmt.Sprintf("TTL mismatch: parent NS TTL is %v, child NS TTL is %v", formatTTLs(parentTTLs), formatTTLs(childTTLs))
+				res.Information = append(res.Information, msg)
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+
+	// Nameservers disagreeing between themselves are reported on each of them
+	if len(childSets) > 1 {
+		for i := range c.output.Results {
+			c.output.Results[i].Vulnerable = true
+			msg := fmt.Sprintf("differing: the nameservers answer %d different NS sets", len(childSets))
+			c.output.Results[i].Information = append(c.output.Results[i].Information, msg)
+		}
+	}
+	return nil
+}
+
+func (c *DelegationCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// normalizeNames lowercases and sorts nameserver names so that sets can be
+// compared
+func normalizeNames(names []string) []string {
+	var normalized []string
+	for _, n := range names {
+		normalized = append(normalized, strings.ToLower(n))
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// nsTTLs returns the distinct TTLs of the NS records, sorted
+func nsTTLs(rrs []dns.RR) []uint32 {
+	seen := make(map[uint32]bool)
+	var ttls []uint32
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok && !seen[ns.Hdr.Ttl] {
+			seen[ns.Hdr.Ttl] = true
+			ttls = append(ttls, ns.Hdr.Ttl)
+		}
+	}
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+	return ttls
+}
+
+// formatTTLs lists the TTLs returned by nsTTLs
+func formatTTLs(ttls []uint32) string {
+	var s []string
+	for _, ttl := range ttls {
+		s = append(s, fmt.Sprintf("%d", ttl))
+	}
+	return strings.Join(s, ", ")
+}
+
+// difference returns the elements of a that are not in b
+func difference(a, b []string) []string {
+	var diff []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, x)
+		}
+	}
+	return diff
+}
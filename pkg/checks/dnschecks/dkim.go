@@ -1,50 +1,91 @@
 package dnschecks
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 
+	"github.com/5amu/dnshunter/pkg/defaults"
 	"github.com/5amu/dnshunter/pkg/output"
 	"github.com/5amu/dnshunter/pkg/utils"
 	"github.com/miekg/dns"
 )
 
 type DKIMCheck struct {
+	// Selectors are known selectors of the domain, probed along with the
+	// built-in catalogue
+	Selectors []string
+	// Wordlist are the selectors loaded with LoadDKIMSelectors, probed after
+	// the built-in catalogue
+	Wordlist []string
+	// Workers is the number of selectors probed concurrently on every
+	// nameserver, defaults.DKIMWorkers is used when zero
+	Workers int
+
 	description []string
+	poc         string
 	client      *dns.Client
 	output      *output.CheckOutput
+	resolver    *utils.Resolver
+
+	mu          sync.Mutex
+	evaluations map[string]*dkimEvaluation
 }
 
 func (c *DKIMCheck) Init(client *dns.Client) error {
 	c.client = client
 	c.description = []string{
 		"DKIM is a TXT record that guarantees that a particular email comes",
-		"from the advertised organization.",
+		"from the advertised organization. The key records of the selectors",
+		"found are parsed as defined by RFC 6376: short RSA keys, keys in",
+		"testing mode and selectors pointing to names that do not exist",
+		"weaken or void the signatures. Selectors are guessed from a catalogue",
+		"of the common mail providers and attributed to them.",
+		"More info at https://www.rfc-editor.org/rfc/rfc6376 and https://www.rfc-editor.org/rfc/rfc8301",
+	}
+	c.poc = "PoC: dig -t TXT +noall +answer %v @%v"
+	if c.Workers == 0 {
+		c.Workers = defaults.DKIMWorkers
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("invalid number of DKIM workers: %v", c.Workers)
+	}
+	for _, s := range c.Selectors {
+		if err := validSelector(s); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// dkimEvaluation is the outcome of the evaluation of the key record of a
+// selector, usable tells if verifiers can use the key
+type dkimEvaluation struct {
+	recordEvaluation
+	usable   bool
+	provider string
+}
+
+// dkimProbe is the outcome of the query for a selector on a nameserver, ev
+// is nil when the selector does not exist
+type dkimProbe struct {
+	name string
+	ev   *dkimEvaluation
+	err  error
+}
+
 func (c *DKIMCheck) Start(domain string, nameservers *utils.Nameservers) error {
-	splittedDomain := strings.Split(domain, ".")
-	sld := splittedDomain[len(splittedDomain)-2]
-	selectors := []string{
-		sld,
-		"default",
-		"dkim",
-		"dkim-shared",
-		"dkimpal",
-		"email",
-		"gamma",
-		"google",
-		"mail",
-		"mdaemon",
-		"selector",
-		"selector1",
-		"selector2",
-		"selector3",
-		"selector4",
-		"selector5",
+	var selectors []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{c.Selectors, catalogueSelectors(domain), c.Wordlist} {
+		for _, s := range list {
+			if s = strings.ToLower(s); !seen[s] {
+				seen[s] = true
+				selectors = append(selectors, s)
+			}
+		}
 	}
 	c.output = &output.CheckOutput{
 		Name:        "DKIM Record",
@@ -53,35 +94,239 @@ func (c *DKIMCheck) Start(domain string, nameservers *utils.Nameservers) error {
 		Description: c.description,
 	}
 
+	c.resolver = nameservers.Resolver
+	c.evaluations = make(map[string]*dkimEvaluation)
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
-		res.Vulnerable = true
-
-		for _, selector := range selectors {
-			r, _ := utils.MakeQuery(
-				c.client,
-				fmt.Sprintf("%v._domainkey.%v.", selector, domain),
-				net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"),
-				dns.TypeTXT,
-			)
-
-			if r != nil && r.Rcode == dns.RcodeSuccess {
-				res.Vulnerable = false
-				msg := fmt.Sprintf("selector = %v", selector)
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
+
+			var found, usable int
+			for i, probe := range c.probeAll(domain, selectors, ip) {
+				if probe.err != nil {
+					res.Information = append(res.Information, fmt.Sprintf("selector %v: query failed: %v", selectors[i], probe.err))
+					continue
+				}
+				ev := probe.ev
+				if ev == nil || len(ev.info) == 0 {
+					continue
+				}
+				found++
+				provider := ev.provider
+				if provider == "" {
+					provider = "unknown provider"
+				}
+				res.Evidence = append(res.Evidence, fmt.Sprintf("%v (%v)", strings.TrimSuffix(probe.name, "."), provider))
+				res.Information = append(res.Information, ev.info...)
+				if ev.vulnerable {
+					res.Vulnerable = true
+					res.Information = append(res.Information, fmt.Sprintf(c.poc, probe.name, ip))
+				}
+				if ev.usable {
+					usable++
+				}
+			}
+			// selectors can't be listed, the domain may use one that was not
+			// probed: only the keys found can tell that signatures fail
+			switch {
+			case found == 0:
+				msg := fmt.Sprintf("no selector found among %d probed, the domain may use one outside of them", len(selectors))
+				res.Information = append(res.Information, msg)
+			case usable == 0:
+				res.Vulnerable = true
+				msg := fmt.Sprintf("no usable DKIM key among the %d selectors found", found)
 				res.Information = append(res.Information, msg)
 			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		if res.Vulnerable {
-			msg := "no DKIM record found on nameserver"
-			res.Information = append(res.Information, msg)
-		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
 
+// probeAll queries the selectors on the nameserver with a pool of workers,
+// the probes are returned in the order of the selectors
+func (c *DKIMCheck) probeAll(domain string, selectors []string, ip net.IP) []dkimProbe {
+	probes := make([]dkimProbe, len(selectors))
+	jobs := make(chan int)
+	go func() {
+		for i := range selectors {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < c.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				probes[i] = c.probe(domain, selectors[i], ip)
+			}
+		}()
+	}
+	wg.Wait()
+	return probes
+}
+
+// probe queries a selector on the nameserver and evaluates its key record,
+// the evaluations are shared by the nameservers giving the same answer
+func (c *DKIMCheck) probe(domain, selector string, ip net.IP) dkimProbe {
+	p := dkimProbe{name: dns.Fqdn(fmt.Sprintf("%v._domainkey.%v", selector, domain))}
+	r, err := utils.MakeNonRecursiveQuery(
+		c.client,
+		p.name,
+		net.JoinHostPort(ip.String(), "53"),
+		dns.TypeTXT,
+	)
+	if err == nil && r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+	}
+	if err != nil {
+		p.err = err
+		return p
+	}
+	if len(r.Answer) == 0 {
+		return p
+	}
+
+	key := p.name + "\n" + rdataSet(r.Answer)
+	c.mu.Lock()
+	ev, ok := c.evaluations[key]
+	c.mu.Unlock()
+	if !ok {
+		ev = c.evaluate(selector, p.name, r.Answer)
+		c.mu.Lock()
+		c.evaluations[key] = ev
+		c.mu.Unlock()
+	}
+	p.ev = ev
+	return p
+}
+
 func (c *DKIMCheck) Results() *output.CheckOutput {
 	return c.output
 }
+
+// evaluate parses the key record of a selector, following the CNAME that
+// delegates the selector to a mail provider
+func (c *DKIMCheck) evaluate(selector, name string, answer []dns.RR) *dkimEvaluation {
+	ev := new(dkimEvaluation)
+	txt, target := txtRecords(answer)
+	ev.provider = dkimProviderOf(selector, target)
+	via := ""
+	if target != "" {
+		via = fmt.Sprintf(" (CNAME to %v)", target)
+	}
+	if ev.provider != "" {
+		via += fmt.Sprintf(" [%v]", ev.provider)
+	}
+	if len(txt) == 0 && target != "" {
+		r, err := c.resolver.Query(target, dns.TypeTXT)
+		if errors.Is(err, utils.ErrNXDomain) {
+			ev.vulnerable = true
+			msg := fmt.Sprintf("selector %v%v: the CNAME target does not exist, the name may be claimable", selector, via)
+			ev.info = append(ev.info, msg)
+			return ev
+		}
+		if err != nil {
+			ev.info = append(ev.info, fmt.Sprintf("selector %v%v: the CNAME target can't be resolved: %v", selector, via, err))
+			return ev
+		}
+		txt, _ = txtRecords(r.Answer)
+		if len(txt) == 0 {
+			ev.vulnerable = true
+			ev.info = append(ev.info, fmt.Sprintf("selector %v%v: the CNAME target has no TXT record", selector, via))
+			return ev
+		}
+	}
+	if len(txt) == 0 {
+		return ev
+	}
+
+	ev.info = append(ev.info, fmt.Sprintf("selector %v%v: %v", selector, via, txt[0]))
+	var findings []string
+	if len(txt) > 1 {
+		findings = append(findings, fmt.Sprintf("%d TXT records published at %v, verifiers may pick any of them", len(txt), name))
+	}
+
+	key, err := utils.ParseDKIMKey(txt[0])
+	if err != nil {
+		ev.vulnerable = true
+		ev.info = append(ev.info, fmt.Sprintf("invalid key record, signatures fail: %v", err))
+		return ev
+	}
+	if key.Revoked() {
+		ev.info = append(ev.info, "the key has been revoked (empty p tag)")
+		ev.info = append(ev.info, findings...)
+		ev.vulnerable = len(findings) > 0
+		return ev
+	}
+
+	info, keyFindings := dkimKeyFindings(key)
+	ev.info = append(ev.info, info...)
+	findings = append(findings, keyFindings...)
+	ev.info = append(ev.info, findings...)
+	ev.vulnerable = len(findings) > 0
+	ev.usable = key.Bits() == 0 || key.Bits() >= defaults.MinDKIMKeySize
+	return ev
+}
+
+// txtRecords returns the TXT records in the answer, joining their strings,
+// and the target of the CNAME chain if any
+func txtRecords(answer []dns.RR) ([]string, string) {
+	var txt []string
+	var target string
+	for _, rr := range answer {
+		switch t := rr.(type) {
+		case *dns.TXT:
+			txt = append(txt, strings.Join(t.Txt, ""))
+		case *dns.CNAME:
+			target = t.Target
+		}
+	}
+	return txt, target
+}
+
+// dkimKeyFindings describes the key and reports its weaknesses
+func dkimKeyFindings(key *utils.DKIMKey) ([]string, []string) {
+	var info, findings []string
+	switch key.KeyType {
+	case utils.DKIMKeyRSA:
+		bits := key.Bits()
+		info = append(info, fmt.Sprintf("%d bits RSA key", bits))
+		switch {
+		case bits < defaults.MinDKIMKeySize:
+			msg := fmt.Sprintf("weak %d bits RSA key, verifiers reject keys shorter than %d bits", bits, defaults.MinDKIMKeySize)
+			findings = append(findings, msg)
+		case bits < defaults.RecommendedDKIMKeySize:
+			msg := fmt.Sprintf("warning: %d bits RSA key, at least %d bits are recommended", bits, defaults.RecommendedDKIMKeySize)
+			info = append(info, msg)
+		}
+	case utils.DKIMKeyEd25519:
+		info = append(info, "ed25519 key (RFC 8463), verifiers not supporting it treat the mail as unsigned unless it is also signed with an RSA key")
+	}
+
+	if key.Testing() {
+		findings = append(findings, "testing mode (t=y): verifiers treat signed mail as unsigned")
+	}
+	if len(key.Hashes) > 0 {
+		var sha256 bool
+		for _, h := range key.Hashes {
+			sha256 = sha256 || h == "sha256"
+		}
+		if !sha256 {
+			findings = append(findings, fmt.Sprintf("h=%v: sha256 is not allowed and verifiers reject sha1 signatures (RFC 8301)", strings.Join(key.Hashes, ":")))
+		}
+	}
+	var email bool
+	for _, s := range key.Services {
+		email = email || s == "*" || s == "email"
+	}
+	if !email {
+		findings = append(findings, fmt.Sprintf("s=%v: the key can't be used for email", strings.Join(key.Services, ":")))
+	}
+	return info, findings
+}
@@ -0,0 +1,192 @@
+package dnschecks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+// dkimProvider describes the selectors a mail provider uses: the fixed ones
+// are probed, patterns match the generated ones (which can't be guessed but
+// can come from a wordlist) and targets are the suffixes of the names the
+// selectors are delegated to with a CNAME
+type dkimProvider struct {
+	name      string
+	selectors []string
+	patterns  []*regexp.Regexp
+	targets   []string
+}
+
+// dkimProviders is the catalogue of the selectors of the common mail
+// providers, used both to probe and to attribute the selectors found
+var dkimProviders = []dkimProvider{
+	{
+		name:      "Google Workspace",
+		selectors: []string{"google"},
+	},
+	{
+		name:      "Microsoft 365",
+		selectors: []string{"selector1", "selector2"},
+		targets:   []string{"onmicrosoft.com"},
+	},
+	{
+		name:      "Mailchimp",
+		selectors: []string{"k1", "k2", "k3"},
+		targets:   []string{"mcsv.net"},
+	},
+	{
+		name:      "Mandrill",
+		selectors: []string{"mandrill"},
+		targets:   []string{"mandrillapp.com"},
+	},
+	{
+		name:      "SendGrid",
+		selectors: []string{"s1", "s2", "smtpapi"},
+		targets:   []string{"sendgrid.net"},
+	},
+	{
+		name:     "Amazon SES",
+		patterns: []*regexp.Regexp{regexp.MustCompile(`^[a-z0-9]{32}$`)},
+		targets:  []string{"amazonses.com"},
+	},
+	{
+		name:      "Mailgun",
+		selectors: []string{"krs", "pic"},
+		targets:   []string{"mailgun.org"},
+	},
+	{
+		name:     "Postmark",
+		patterns: []*regexp.Regexp{regexp.MustCompile(`^[0-9]{14}pm$`)},
+		targets:  []string{"mtasv.net"},
+	},
+	{
+		name:      "HubSpot",
+		selectors: []string{"hs1", "hs2"},
+		patterns:  []*regexp.Regexp{regexp.MustCompile(`^hs[12]-[0-9]+$`)},
+		targets:   []string{"hubspotemail.net"},
+	},
+	{
+		name:      "Brevo",
+		selectors: []string{"brevo1", "brevo2"},
+		targets:   []string{"sendinblue.com", "brevo.com"},
+	},
+	{
+		name:      "Zoho",
+		selectors: []string{"zoho", "zmail"},
+		targets:   []string{"zoho.com"},
+	},
+	{
+		name:      "Zendesk",
+		selectors: []string{"zendesk1", "zendesk2"},
+		targets:   []string{"zendesk.com"},
+	},
+	{
+		name:      "Fastmail",
+		selectors: []string{"fm1", "fm2", "fm3"},
+		targets:   []string{"messagingengine.com"},
+	},
+	{
+		name:      "Proton Mail",
+		selectors: []string{"protonmail", "protonmail2", "protonmail3"},
+		targets:   []string{"protonmail.ch"},
+	},
+	{
+		name:      "Mailjet",
+		selectors: []string{"mailjet"},
+	},
+}
+
+// genericSelectors are the selectors commonly picked by self-hosted servers
+var genericSelectors = []string{
+	"default",
+	"dkim",
+	"dkim-shared",
+	"dkimpal",
+	"email",
+	"gamma",
+	"mail",
+	"mdaemon",
+	"selector",
+	"selector3",
+	"selector4",
+	"selector5",
+}
+
+// dkimProviderOf attributes a selector to a provider, from the target of its
+// CNAME first and from its name otherwise. An empty string means unknown
+func dkimProviderOf(selector, target string) string {
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	if target != "" {
+		for _, p := range dkimProviders {
+			for _, t := range p.targets {
+				if dns.IsSubDomain(t, target) {
+					return p.name
+				}
+			}
+		}
+	}
+	selector = strings.ToLower(selector)
+	for _, p := range dkimProviders {
+		for _, s := range p.selectors {
+			if s == selector {
+				return p.name
+			}
+		}
+		for _, re := range p.patterns {
+			if re.MatchString(selector) {
+				return p.name
+			}
+		}
+	}
+	return ""
+}
+
+// catalogueSelectors returns the selectors probed by default for a domain
+func catalogueSelectors(domain string) []string {
+	var selectors []string
+	if name := utils.OrganizationalName(domain); name != "" {
+		selectors = append(selectors, name)
+	}
+	for _, p := range dkimProviders {
+		selectors = append(selectors, p.selectors...)
+	}
+	return append(selectors, genericSelectors...)
+}
+
+// LoadDKIMSelectors reads a wordlist of selectors, one per line. Empty lines
+// and lines starting with # are skipped
+func LoadDKIMSelectors(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var selectors []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if err := validSelector(s); err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", path, line, err)
+		}
+		selectors = append(selectors, strings.ToLower(s))
+	}
+	return selectors, scanner.Err()
+}
+
+// validSelector tells if the selector can be queried, it may be made of more
+// than one label (RFC 6376 section 3.1)
+func validSelector(selector string) error {
+	if _, ok := dns.IsDomainName(selector); !ok || strings.HasSuffix(selector, ".") {
+		return fmt.Errorf("invalid selector %q", selector)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package dnschecks
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -15,15 +16,26 @@ type DMARCCheck struct {
 	poc         string
 	client      *dns.Client
 	output      *output.CheckOutput
+	resolver    *utils.Resolver
+	discovery   *utils.DMARCDiscovery
+	walkErr     error
+	exists      bool
 }
 
 func (c *DMARCCheck) Init(client *dns.Client) error {
 	c.client = client
 	c.description = []string{
 		"DMARC is a record that correlates SPF and DKIM and takes action",
-		"according to its policy: none, quarantine, reject.",
+		"according to its policy: none, quarantine, reject. The record is",
+		"parsed as defined by RFC 7489, a policy applied to a fraction of the",
+		"mail, a weaker policy for subdomains or the lack of aggregate reports",
+		"leave room for spoofing that goes unnoticed. Reports sent to another",
+		"domain are dropped unless that domain authorizes them. Names without",
+		"a record are governed by the one of a parent domain, found with the",
+		"DMARCbis tree walk, through the sp and np tags.",
+		"More info at https://www.rfc-editor.org/rfc/rfc7489",
 	}
-	c.poc = "dig -t TXT +noall +answer _dmarc.%v @%v"
+	c.poc = "PoC: dig -t TXT +noall +answer _dmarc.%v @%v"
 	return nil
 }
 
@@ -35,42 +47,46 @@ func (c *DMARCCheck) Start(domain string, nameservers *utils.Nameservers) error
 		Description: c.description,
 	}
 
+	c.resolver = nameservers.Resolver
+	c.discovery, c.walkErr = utils.DMARCTreeWalk(c.resolver, domain)
+	_, err := c.resolver.Query(domain, dns.TypeSOA)
+	c.exists = !errors.Is(err, utils.ErrNXDomain)
+
+	name := dns.Fqdn(fmt.Sprintf("_dmarc.%v", domain))
+	evaluations := make(map[string]*recordEvaluation)
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
-
-		r, err := utils.MakeQuery(
-			c.client,
-			dns.Fqdn(fmt.Sprintf("_dmarc.%v", domain)),
-			net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"),
-			dns.TypeTXT,
-		)
-		if err != nil {
-			return err
-		}
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
+
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				name,
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeTXT,
+			)
+			if err == nil && r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+				err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+			}
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
 
-		for _, a := range r.Answer {
-			switch t := a.(type) {
-			case *dns.TXT:
-				if strings.Contains(t.Txt[0], "v=dmarc") {
-					if strings.Contains(t.Txt[0], "p=quarantine") {
-						res.Vulnerable = true
-						res.Information = append(res.Information, "partially secure policy: quarantine")
-						msg := fmt.Sprintf(c.poc, domain, fqdn)
-						res.Information = append(res.Information, msg)
-					}
-					if strings.Contains(t.Txt[0], "p=none") {
-						res.Vulnerable = true
-						res.Information = append(res.Information, "insecure policy: none")
-						msg := fmt.Sprintf(c.poc, domain, fqdn)
-						res.Information = append(res.Information, msg)
-					}
-					break
-				}
+			key := rdataSet(r.Answer)
+			if _, ok := evaluations[key]; !ok {
+				evaluations[key] = c.evaluate(domain, r.Answer)
+			}
+			res.Information = append([]string{}, evaluations[key].info...)
+			res.Vulnerable = evaluations[key].vulnerable
+			if res.Vulnerable {
+				res.Information = append(res.Information, fmt.Sprintf(c.poc, domain, ip))
 			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
@@ -78,3 +94,252 @@ func (c *DMARCCheck) Start(domain string, nameservers *utils.Nameservers) error
 func (c *DMARCCheck) Results() *output.CheckOutput {
 	return c.output
 }
+
+// evaluate parses the DMARC record among the TXT records of the _dmarc name
+// of the domain and reports the weaknesses of its tags, the record of a
+// parent domain is looked for when the domain has none
+func (c *DMARCCheck) evaluate(domain string, txt []dns.RR) *recordEvaluation {
+	ev := new(recordEvaluation)
+	// the record is often delegated to the reporting provider with a CNAME,
+	// which the authoritative servers do not follow
+	records, target := txtRecords(txt)
+	if len(records) == 0 && target != "" {
+		ev.info = append(ev.info, fmt.Sprintf("_dmarc.%v is a CNAME to %v", domain, target))
+		r, err := c.resolver.Query(target, dns.TypeTXT)
+		if errors.Is(err, utils.ErrNXDomain) {
+			ev.vulnerable = true
+			ev.info = append(ev.info, "the CNAME target does not exist, the name may be claimable and receivers apply no policy")
+			return ev
+		}
+		if err != nil {
+			ev.info = append(ev.info, fmt.Sprintf("the CNAME target can't be resolved: %v", err))
+			return ev
+		}
+		txt = r.Answer
+	}
+	record, err := utils.SelectDMARC(txt)
+	if err != nil {
+		ev.vulnerable = true
+		ev.info = append(ev.info, fmt.Sprintf("_dmarc.%v: %v", domain, err))
+		return ev
+	}
+	if record == "" {
+		return c.inherited(domain)
+	}
+
+	ev.info = append(ev.info, fmt.Sprintf("_dmarc.%v: %v", domain, record))
+	dmarc, err := utils.ParseDMARC(record)
+	if err != nil {
+		ev.vulnerable = true
+		ev.info = append(ev.info, fmt.Sprintf("invalid record, receivers ignore it: %v", err))
+		return ev
+	}
+	ev.info = append(ev.info, fmt.Sprintf("%v is governed by its own record, applied policy: p=%v", domain, dmarc.Policy))
+	c.audit(ev, domain, dmarc, dmarc.Policy)
+	return ev
+}
+
+// inherited reports the record governing a domain that publishes none, as
+// found by the DMARCbis tree walk and by the RFC 7489 fallback to the
+// organizational domain
+func (c *DMARCCheck) inherited(domain string) *recordEvaluation {
+	ev := new(recordEvaluation)
+	ev.info = append(ev.info, fmt.Sprintf("No DMARC record at _dmarc.%v", domain))
+
+	// RFC 7489 receivers only fall back to the organizational domain taken
+	// from the public suffix list
+	walk := c.discovery
+	var fallback string
+	if org, err := utils.RegistrableDomain(domain); err == nil && org != domain && org != walk.Source {
+		fallback = fmt.Sprintf("RFC 7489 fallback: no DMARC record at _dmarc.%v", org)
+		if r, err := c.resolver.Query("_dmarc."+org, dns.TypeTXT); err == nil {
+			if record, err := utils.SelectDMARC(r.Answer); err == nil && record != "" {
+				fallback = fmt.Sprintf("RFC 7489 fallback to the organizational domain: _dmarc.%v: %v", org, record)
+			}
+		}
+	}
+
+	if c.walkErr != nil {
+		ev.info = append(ev.info, fmt.Sprintf("DMARCbis tree walk incomplete: %v", c.walkErr))
+	}
+	for _, s := range walk.Skipped {
+		ev.info = append(ev.info, fmt.Sprintf("ignored during the tree walk: %v", s))
+	}
+	if fallback != "" {
+		ev.info = append(ev.info, fallback)
+	}
+	if walk.Record == nil {
+		ev.vulnerable = c.walkErr == nil
+		ev.info = append(ev.info, fmt.Sprintf("No DMARC record for %v or its parent domains (queried %v)", domain, strings.Join(walk.Queried, ", ")))
+		return ev
+	}
+
+	policy, tag := walk.Policy(c.exists)
+	msg := fmt.Sprintf(
+		"%v is governed by the record of %v found by the DMARCbis tree walk (organizational domain %v), applied policy: %v=%v",
+		domain, walk.Source, walk.OrganizationalDomain, tag, policy,
+	)
+	ev.info = append(ev.info, msg)
+	ev.info = append(ev.info, fmt.Sprintf("_dmarc.%v: %v", walk.Source, walk.Record.Raw))
+	c.audit(ev, walk.Source, walk.Record, policy)
+	return ev
+}
+
+// audit reports the tags of the record published by source, applied is the
+// policy that receivers apply to the assessed domain
+func (c *DMARCCheck) audit(ev *recordEvaluation, source string, dmarc *utils.DMARCRecord, applied string) {
+	ev.info = append(ev.info, fmt.Sprintf(
+		"policy: p=%v sp=%v np=%v pct=%d adkim=%v aspf=%v",
+		dmarc.Policy, dmarc.SubdomainPolicy, dmarc.NonexistentPolicy, dmarc.Percent, dmarc.ADKIM, dmarc.ASPF,
+	))
+	if len(dmarc.AggregateURIs) > 0 {
+		ev.info = append(ev.info, fmt.Sprintf("aggregate reports sent to: %v", reportURIs(dmarc.AggregateURIs)))
+	}
+	if len(dmarc.FailureURIs) > 0 {
+		ev.info = append(ev.info, fmt.Sprintf("failure reports sent to: %v (fo=%v)", reportURIs(dmarc.FailureURIs), strings.Join(dmarc.FailureOptions, ":")))
+	}
+	for _, t := range []string{"fo", "rf"} {
+		if _, ok := dmarc.Tags[t]; ok && len(dmarc.FailureURIs) == 0 {
+			ev.info = append(ev.info, fmt.Sprintf("%v has no effect without ruf", t))
+		}
+	}
+
+	info, unauthorized := c.reportDestinations(source, dmarc)
+	ev.info = append(ev.info, info...)
+
+	findings := append(dmarcFindings(source, dmarc, applied), unauthorized...)
+	ev.info = append(ev.info, findings...)
+	ev.vulnerable = len(findings) > 0
+}
+
+// dmarcFindings reports the tags of a valid record that weaken the policy or
+// hide its failures
+func dmarcFindings(source string, d *utils.DMARCRecord, applied string) []string {
+	var findings []string
+	switch applied {
+	case utils.DMARCNone:
+		findings = append(findings, "insecure policy: none")
+	case utils.DMARCQuarantine:
+		findings = append(findings, "partially secure policy: quarantine")
+	}
+	if _, ok := d.Tags["p"]; !ok {
+		findings = append(findings, "missing p tag, receivers act as if p=none")
+	}
+	if utils.DMARCStronger(d.Policy, d.SubdomainPolicy) {
+		findings = append(findings, fmt.Sprintf("subdomain policy %v weaker than the domain policy %v", d.SubdomainPolicy, d.Policy))
+	}
+	if _, ok := d.Tags["np"]; ok && utils.DMARCStronger(d.SubdomainPolicy, d.NonexistentPolicy) {
+		findings = append(findings, fmt.Sprintf("policy for non-existent subdomains %v weaker than the subdomain policy %v", d.NonexistentPolicy, d.SubdomainPolicy))
+	}
+	if _, err := utils.RegistrableDomain(source); err == nil && d.PSD == "y" {
+		findings = append(findings, fmt.Sprintf("psd=y published by %v, which is not a public suffix", source))
+	}
+	if d.Percent < 100 {
+		findings = append(findings, fmt.Sprintf("pct=%d: the policy is applied to %d%% of the failing mail only", d.Percent, d.Percent))
+	}
+	if len(d.AggregateURIs) == 0 {
+		findings = append(findings, "no aggregate reporting: rua is missing, failures go unnoticed")
+	}
+	return findings
+}
+
+// reportDestinations verifies that the domains receiving the reports accept
+// mail and, when they belong to another organization, that they authorize
+// the reports for domain (RFC 7489 section 7.1). It returns the information
+// about the destinations and the findings
+func (c *DMARCCheck) reportDestinations(domain string, d *utils.DMARCRecord) ([]string, []string) {
+	var info, findings []string
+	org, _ := utils.RegistrableDomain(domain)
+	for _, tag := range []struct {
+		name string
+		uris []utils.DMARCURI
+	}{
+		{"rua", d.AggregateURIs},
+		{"ruf", d.FailureURIs},
+	} {
+		for _, u := range tag.uris {
+			dest := u.Domain()
+			if dest == "" {
+				findings = append(findings, fmt.Sprintf("%v destination %v: receivers are only required to support mailto URIs", tag.name, u))
+				continue
+			}
+			if err := c.acceptsMail(dest); err != nil {
+				findings = append(findings, fmt.Sprintf("%v destination %v: %v, reports are lost", tag.name, u, err))
+				continue
+			}
+			if destOrg, _ := utils.RegistrableDomain(dest); destOrg == org {
+				continue
+			}
+
+			auth := fmt.Sprintf("%v._report._dmarc.%v", domain, dest)
+			record, err := c.authorization(auth)
+			if err != nil {
+				findings = append(findings, fmt.Sprintf("%v destination %v is not authorized to receive reports for %v: %v", tag.name, u, domain, err))
+				continue
+			}
+			msg := fmt.Sprintf("%v destination %v authorized by %v", tag.name, u, auth)
+			if ad, err := utils.ParseDMARC(record); err == nil && len(ad.AggregateURIs)+len(ad.FailureURIs) > 0 {
+				msg += fmt.Sprintf(", which redirects the reports to %v", reportURIs(append(ad.AggregateURIs, ad.FailureURIs...)))
+			}
+			info = append(info, msg)
+		}
+	}
+	return info, findings
+}
+
+// acceptsMail tells why the domain can't receive the reports, if it can't
+func (c *DMARCCheck) acceptsMail(domain string) error {
+	r, err := c.resolver.Query(domain, dns.TypeMX)
+	if errors.Is(err, utils.ErrNXDomain) {
+		return fmt.Errorf("%v does not exist", domain)
+	}
+	if err != nil {
+		return fmt.Errorf("%v can't be resolved: %v", domain, err)
+	}
+
+	var mx []*dns.MX
+	for _, rr := range r.Answer {
+		if t, ok := rr.(*dns.MX); ok {
+			mx = append(mx, t)
+		}
+	}
+	// a null MX tells the domain accepts no mail (RFC 7505)
+	if len(mx) == 1 && mx[0].Mx == "." {
+		return fmt.Errorf("%v publishes a null MX", domain)
+	}
+	if len(mx) == 0 {
+		if _, err := c.resolver.LookupIPs(domain); err != nil {
+			return fmt.Errorf("%v has no MX nor address record", domain)
+		}
+	}
+	return nil
+}
+
+// authorization returns the DMARC record published by the destination to
+// accept the reports of another domain
+func (c *DMARCCheck) authorization(name string) (string, error) {
+	r, err := c.resolver.Query(name, dns.TypeTXT)
+	if errors.Is(err, utils.ErrNXDomain) {
+		return "", fmt.Errorf("%v does not exist", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	record, err := utils.SelectDMARC(r.Answer)
+	if err != nil {
+		return "", err
+	}
+	if record == "" {
+		return "", fmt.Errorf("no v=DMARC1 record at %v", name)
+	}
+	return record, nil
+}
+
+// reportURIs lists the report destinations of a record for the output
+func reportURIs(uris []utils.DMARCURI) string {
+	var s []string
+	for _, u := range uris {
+		s = append(s, u.String())
+	}
+	return strings.Join(s, ", ")
+}
@@ -3,6 +3,7 @@ package dnschecks
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/5amu/dnshunter/pkg/output"
 	"github.com/5amu/dnshunter/pkg/utils"
@@ -22,9 +23,12 @@ func (c *DNSSECCheck) Init(client *dns.Client) error {
 		"DNSSEC is a suite of extensions aimed to guarantee secure data",
 		"exchange between the name server and the client. It guarantees data",
 		"integrity and denial of exitence. Its mean is to avoid zone",
-		"enumeration and prevent from manipulated answers and cache poisoning",
+		"enumeration and prevent from manipulated answers and cache poisoning.",
+		"The chain of trust is validated from the root trust anchor down to",
+		"the DNSKEY, SOA and NS records of the zone on every nameserver.",
+		"More info at https://www.rfc-editor.org/rfc/rfc4035#section-5",
 	}
-	c.poc = "dig -t DNSKEY +noall +answer %v @%v"
+	c.poc = "PoC: dig -t %v +dnssec +norecurse %v @%v"
 	return nil
 }
 
@@ -36,27 +40,45 @@ func (c *DNSSECCheck) Start(domain string, nameservers *utils.Nameservers) error
 		Description: c.description,
 	}
 
+	chain := utils.ValidateChain(nameservers.Zone, nameservers.Resolver)
+	var steps []string
+	for _, l := range chain.Links {
+		steps = append(steps, fmt.Sprintf("%v (%v)", l.Zone, l.State))
+	}
+
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+			res.Information = append(res.Information, fmt.Sprintf("chain of trust: %v", strings.Join(steps, " -> ")))
 
-		r, err := utils.MakeQuery(
-			c.client,
-			dns.Fqdn(domain),
-			net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"),
-			dns.TypeDNSKEY,
-		)
-		if err != nil {
-			return err
-		}
+			state := chain.State
+			if link := chain.FailingLink(); link != nil {
+				res.Information = append(res.Information, fmt.Sprintf("failing link: %v", link))
+			} else {
+				// the chain is secure up to the keys of the zone, each
+				// nameserver has to serve records signed by them
+				for _, qType := range []uint16{dns.TypeDNSKEY, dns.TypeSOA, dns.TypeNS} {
+					s, reason := c.verify(nameservers.Zone, ip, qType, chain.Keys)
+					if s == utils.DNSSECSecure {
+						continue
+					}
+					if state == utils.DNSSECSecure || s == utils.DNSSECBogus {
+						state = s
+					}
+					res.Information = append(res.Information, reason)
+					res.Information = append(res.Information, fmt.Sprintf(c.poc, dns.TypeToString[qType], nameservers.Zone, ip))
+				}
+			}
 
-		if len(r.Answer) == 0 {
-			res.Vulnerable = true
-			msg := fmt.Sprintf(c.poc, domain, fqdn)
-			res.Information = append(res.Information, msg)
+			res.Information = append(res.Information, fmt.Sprintf("validation state: %v", state))
+			if state == utils.DNSSECInsecure || state == utils.DNSSECBogus {
+				res.Vulnerable = true
+			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
@@ -64,3 +86,25 @@ func (c *DNSSECCheck) Start(domain string, nameservers *utils.Nameservers) error
 func (c *DNSSECCheck) Results() *output.CheckOutput {
 	return c.output
 }
+
+// verify asks the nameserver for the RRset of the zone apex and validates
+// its signatures with the keys of the zone
+func (c *DNSSECCheck) verify(zone string, ip net.IP, qType uint16, keys []*dns.DNSKEY) (string, string) {
+	name := dns.TypeToString[qType]
+	r, err := utils.MakeDNSSECQuery(c.client, zone, net.JoinHostPort(ip.String(), "53"), qType)
+	if err == nil && r.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+	}
+	if err != nil {
+		return utils.DNSSECIndeterminate, fmt.Sprintf("%v query failed: %v", name, err)
+	}
+
+	rrset := utils.ExtractRRset(r.Answer, zone, qType)
+	if len(rrset) == 0 {
+		return utils.DNSSECBogus, fmt.Sprintf("no %v record in the answer", name)
+	}
+	if err := utils.VerifyRRset(rrset, utils.ExtractRRSIGs(r.Answer, zone, qType), keys); err != nil {
+		return utils.DNSSECBogus, fmt.Sprintf("%v validation failed: %v", name, err)
+	}
+	return utils.DNSSECSecure, ""
+}
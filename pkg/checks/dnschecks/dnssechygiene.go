@@ -0,0 +1,173 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+// deprecatedAlgorithms are the DNSKEY algorithms that must not or should not
+// be used for signing anymore (RFC 8624 section 3.1)
+var deprecatedAlgorithms = map[uint8]bool{
+	dns.RSAMD5:           true,
+	dns.DSA:              true,
+	dns.RSASHA1:          true,
+	dns.DSANSEC3SHA1:     true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.ECCGOST:          true,
+}
+
+// deprecatedDigests are the DS digest types that must not be used anymore
+// (RFC 8624 section 3.3)
+var deprecatedDigests = map[uint8]bool{
+	dns.SHA1:   true,
+	dns.GOST94: true,
+}
+
+type DNSSECHygieneCheck struct {
+	// ExpiryWindow is how long before their expiration the signatures are
+	// reported, defaults.SignatureExpiryWindow is used when zero
+	ExpiryWindow time.Duration
+
+	description []string
+	poc         string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *DNSSECHygieneCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"The signatures of a zone must be refreshed before they expire, or the",
+		"zone becomes bogus for validating resolvers. Keys should use algorithms",
+		"and sizes that are still considered secure, and the DS records in the",
+		"parent zone should not use the SHA-1 digest.",
+		"More info at https://www.rfc-editor.org/rfc/rfc8624",
+	}
+	c.poc = "PoC: dig -t %v +dnssec +norecurse %v @%v"
+	if c.ExpiryWindow == 0 {
+		c.ExpiryWindow = defaults.SignatureExpiryWindow
+	}
+	if c.ExpiryWindow < 0 {
+		return fmt.Errorf("invalid signature expiry window: %v", c.ExpiryWindow)
+	}
+	return nil
+}
+
+func (c *DNSSECHygieneCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "DNSSEC Signatures and Algorithms",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	// The DS set is the same whatever nameserver of the zone is asked, it
+	// is published by the parent
+	var dsInfo []string
+	var dsVuln bool
+	if r, err := nameservers.Resolver.Query(dns.Fqdn(nameservers.Zone), dns.TypeDS); err != nil {
+		dsInfo = append(dsInfo, fmt.Sprintf("DS query failed: %v", err))
+	} else {
+		for _, rr := range utils.ExtractRRset(r.Answer, nameservers.Zone, dns.TypeDS) {
+			ds := rr.(*dns.DS)
+			if deprecatedDigests[ds.DigestType] {
+				dsVuln = true
+				msg := fmt.Sprintf("DS %d uses the deprecated %v digest", ds.KeyTag, dns.HashToString[ds.DigestType])
+				dsInfo = append(dsInfo, msg)
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+			res.Information = append(res.Information, dsInfo...)
+			res.Vulnerable = dsVuln
+
+			for _, qType := range []uint16{dns.TypeDNSKEY, dns.TypeSOA, dns.TypeNS} {
+				r, err := utils.MakeDNSSECQuery(
+					c.client,
+					nameservers.Zone,
+					net.JoinHostPort(ip.String(), "53"),
+					qType,
+				)
+				if err == nil && r.Rcode != dns.RcodeSuccess {
+					err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+				}
+				if err != nil {
+					res.Information = append(res.Information, fmt.Sprintf("%v query failed: %v", dns.TypeToString[qType], err))
+					continue
+				}
+
+				if qType == dns.TypeDNSKEY {
+					for _, rr := range utils.ExtractRRset(r.Answer, nameservers.Zone, dns.TypeDNSKEY) {
+						for _, msg := range keyFindings(rr.(*dns.DNSKEY)) {
+							res.Vulnerable = true
+							res.Information = append(res.Information, msg)
+						}
+					}
+				}
+
+				var found bool
+				for _, sig := range utils.ExtractRRSIGs(r.Answer, nameservers.Zone, qType) {
+					if msg := c.signatureFinding(sig, now); msg != "" {
+						found = true
+						res.Information = append(res.Information, msg)
+					}
+				}
+				if found {
+					res.Vulnerable = true
+					res.Information = append(res.Information, fmt.Sprintf(c.poc, dns.TypeToString[qType], nameservers.Zone, ip))
+				}
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *DNSSECHygieneCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// signatureFinding tells if the signature is expired, not yet valid or about
+// to expire within the window, an empty string means it is fine
+func (c *DNSSECHygieneCheck) signatureFinding(sig *dns.RRSIG, now time.Time) string {
+	covered := dns.TypeToString[sig.TypeCovered]
+	inception := time.Unix(int64(sig.Inception), 0)
+	expiration := time.Unix(int64(sig.Expiration), 0)
+	switch {
+	case now.After(expiration):
+		return fmt.Sprintf("RRSIG over %v by key %d expired on %v", covered, sig.KeyTag, expiration.UTC().Format(time.RFC3339))
+	case now.Before(inception):
+		return fmt.Sprintf("RRSIG over %v by key %d is not valid before %v", covered, sig.KeyTag, inception.UTC().Format(time.RFC3339))
+	case expiration.Sub(now) < c.ExpiryWindow:
+		left := expiration.Sub(now).Round(time.Minute)
+		return fmt.Sprintf("RRSIG over %v by key %d expires in %v, on %v", covered, sig.KeyTag, left, expiration.UTC().Format(time.RFC3339))
+	}
+	return ""
+}
+
+// keyFindings reports deprecated algorithms and weak RSA keys
+func keyFindings(key *dns.DNSKEY) []string {
+	var findings []string
+	alg := dns.AlgorithmToString[key.Algorithm]
+	if deprecatedAlgorithms[key.Algorithm] {
+		findings = append(findings, fmt.Sprintf("key %d uses the deprecated algorithm %v (%d)", key.KeyTag(), alg, key.Algorithm))
+	}
+	if size := utils.RSAKeySize(key); size != 0 && size < defaults.MinRSAKeySize {
+		msg := fmt.Sprintf("key %d is a weak %d bits %v key, at least %d bits are recommended", key.KeyTag(), size, alg, defaults.MinRSAKeySize)
+		findings = append(findings, msg)
+	}
+	return findings
+}
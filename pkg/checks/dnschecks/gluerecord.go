@@ -1,6 +1,7 @@
 package dnschecks
 
 import (
+	"fmt"
 	"net"
 	"strings"
 
@@ -35,32 +36,48 @@ func (c *GLUECheck) Start(domain string, nameservers *utils.Nameservers) error {
 	}
 
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
 
-		r, err := utils.MakeQuery(
-			c.client,
-			dns.Fqdn(domain),
-			net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"),
-			dns.TypeNS,
-		)
-		if err != nil {
-			return err
-		}
+			r, err := utils.MakeQuery(
+				c.client,
+				dns.Fqdn(nameservers.Zone),
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeNS,
+			)
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
 
-		// Check every record in the additional section, every nameserver should have its
-		// own A or AAAA record inside of it
-		res.Vulnerable = true
-		for _, a := range r.Extra {
-			switch t := a.(type) {
-			case *dns.A, *dns.AAAA:
-				if strings.Contains(t.String(), string(nameservers.GetIP(fqdn))) {
-					res.Vulnerable = false
+			// Check every record in the additional section, every nameserver should have its
+			// own A or AAAA record inside of it
+			res.Vulnerable = true
+			for _, a := range r.Extra {
+				if !strings.EqualFold(a.Header().Name, dns.Fqdn(fqdn)) {
+					continue
 				}
+				switch t := a.(type) {
+				case *dns.A:
+					if t.A.Equal(ip) {
+						res.Vulnerable = false
+					}
+				case *dns.AAAA:
+					if t.AAAA.Equal(ip) {
+						res.Vulnerable = false
+					}
+				}
+			}
+			if res.Vulnerable {
+				msg := fmt.Sprintf("no glue record for %v (%v) in the additional section", fqdn, ip)
+				res.Information = append(res.Information, msg)
 			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
@@ -0,0 +1,87 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+type IPv6Check struct {
+	description []string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *IPv6Check) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"At least one nameserver of the zone should be reachable over IPv6,",
+		"otherwise IPv6-only resolvers are not able to resolve any name in it.",
+		"More info at https://www.rfc-editor.org/rfc/rfc3901",
+	}
+	return nil
+}
+
+func (c *IPv6Check) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "IPv6 Reachability",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	var reachable, untested bool
+	for _, fqdn := range nameservers.FQDNs {
+		var res output.SingleCheckResult
+		res.Nameserver = fqdn
+		res.Zone = nameservers.Zone
+
+		var v6 []net.IP
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			if utils.IsIPv6(ip) {
+				v6 = append(v6, ip)
+			}
+		}
+		if len(v6) == 0 {
+			res.Information = append(res.Information, fmt.Sprintf("%v has no AAAA record", fqdn))
+		}
+
+		for _, ip := range v6 {
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				nameservers.Zone,
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeSOA,
+			)
+			if err != nil && utils.NoLocalRoute(err) {
+				untested = true
+				res.Information = append(res.Information, fmt.Sprintf("%v not tested (no local IPv6 connectivity)", ip))
+				continue
+			}
+			if err != nil || r.Rcode != dns.RcodeSuccess {
+				res.Information = append(res.Information, fmt.Sprintf("%v did not answer over IPv6", ip))
+				continue
+			}
+			reachable = true
+			res.Information = append(res.Information, fmt.Sprintf("%v answers over IPv6", ip))
+		}
+		c.output.Results = append(c.output.Results, res)
+	}
+
+	// the scanning host can't tell whether the untested addresses answer
+	if !reachable && !untested {
+		for i := range c.output.Results {
+			c.output.Results[i].Vulnerable = true
+			msg := "no nameserver of the zone is reachable over IPv6"
+			c.output.Results[i].Information = append(c.output.Results[i].Information, msg)
+		}
+	}
+	return nil
+}
+
+func (c *IPv6Check) Results() *output.CheckOutput {
+	return c.output
+}
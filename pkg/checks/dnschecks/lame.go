@@ -0,0 +1,139 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+type LameCheck struct {
+	description []string
+	poc         string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *LameCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"A lame delegation happens when a nameserver listed for the zone does not",
+		"answer authoritatively for it: it times out, refuses the query, fails",
+		"or answers without the AA bit. Resolvers waste time on lame servers and",
+		"a lame server whose name expires can be registered by an attacker to",
+		"hijack the zone. More info at https://www.rfc-editor.org/rfc/rfc8499",
+	}
+	c.poc = "dig -t SOA +norecurse %v @%v"
+	return nil
+}
+
+func (c *LameCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "Lame Delegation",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	// Check the servers delegated by the parent as well as the ones listed
+	// in the zone, both sets are used by resolvers
+	delegated := nameservers.Child.Names()
+	if nameservers.Parent != nil {
+		for _, fqdn := range nameservers.Parent.Names() {
+			if !containsFold(delegated, fqdn) {
+				delegated = append(delegated, fqdn)
+			}
+		}
+	}
+
+	for _, fqdn := range delegated {
+		var res output.SingleCheckResult
+		res.Nameserver = fqdn
+		res.Zone = nameservers.Zone
+
+		ips, err := c.addresses(fqdn, nameservers)
+		if err != nil {
+			res.Vulnerable = true
+			res.Information = append(res.Information, fmt.Sprintf("unable to resolve the nameserver address: %v", err))
+			c.output.Results = append(c.output.Results, res)
+			continue
+		}
+
+		for _, ip := range ips {
+			res := res
+			res.Address = ip.String()
+
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				nameservers.Zone,
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeSOA,
+			)
+			switch {
+			case err != nil && utils.IsIPv6(ip) && utils.NoLocalRoute(err):
+				// a fault of the scanning host, not of the nameserver
+				res.Information = append(res.Information, fmt.Sprintf("%v not tested (no local IPv6 connectivity): %v", ip, err))
+			case err != nil:
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("no answer from %v: %v", ip, err))
+			case r.Rcode != dns.RcodeSuccess:
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("%v answered %v", ip, dns.RcodeToString[r.Rcode]))
+			case !r.Authoritative:
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("%v answered without the AA bit", ip))
+			case !hasSOA(r, nameservers.Zone):
+				res.Vulnerable = true
+				res.Information = append(res.Information, fmt.Sprintf("%v did not return the SOA of %v", ip, nameservers.Zone))
+			}
+			if res.Vulnerable {
+				res.Information = append(res.Information, fmt.Sprintf(c.poc, nameservers.Zone, ip))
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *LameCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// addresses returns the IPv4 and IPv6 addresses of a delegated nameserver,
+// using the glue when the nameserver is only listed by the parent
+func (c *LameCheck) addresses(fqdn string, nameservers *utils.Nameservers) ([]net.IP, error) {
+	if ips := nameservers.GetIPs(fqdn); len(ips) > 0 {
+		return ips, nil
+	}
+	if nameservers.Parent != nil {
+		if ips := nameservers.Parent.GlueFor(fqdn); len(ips) > 0 {
+			return ips, nil
+		}
+	}
+	if err, ok := nameservers.Unresolved[fqdn]; ok {
+		return nil, err
+	}
+
+	return nameservers.Resolver.LookupIPs(fqdn)
+}
+
+func hasSOA(r *dns.Msg, zone string) bool {
+	for _, a := range r.Answer {
+		if t, ok := a.(*dns.SOA); ok && strings.EqualFold(t.Hdr.Name, dns.Fqdn(zone)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
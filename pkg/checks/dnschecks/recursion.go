@@ -0,0 +1,96 @@
+package dnschecks
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+type RecursionCheck struct {
+	description []string
+	poc         string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *RecursionCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"Authoritative nameservers should not resolve names outside of the zones",
+		"they serve. An open resolver can be abused for DNS amplification attacks",
+		"against third parties and is exposed to cache poisoning.",
+		"More info at https://www.rfc-editor.org/rfc/rfc5358",
+	}
+	c.poc = "PoC: dig -t A +recurse %v @%v"
+	return nil
+}
+
+func (c *RecursionCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "Open Recursive Resolver",
+		Severity:    output.SeverityHigh,
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	probe := recursionProbe(nameservers.Zone)
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(probe), dns.TypeA)
+			m.RecursionDesired = true
+
+			r, err := utils.Exchange(c.client, m, net.JoinHostPort(ip.String(), "53"))
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			// Only an answer to an out-of-zone name proves that the server
+			// recursed, the RA flag alone might be set by misconfigured
+			// servers that refuse the query anyway
+			resolved := r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0
+			switch {
+			case resolved:
+				res.Vulnerable = true
+				msg := fmt.Sprintf("%v resolved %v (RA=%v), recursion is open", ip, probe, r.RecursionAvailable)
+				res.Information = append(res.Information, msg)
+				res.Information = append(res.Information, fmt.Sprintf(c.poc, probe, ip))
+			case r.RecursionAvailable:
+				msg := fmt.Sprintf("%v advertises recursion (RA=true) but answered %v for %v", ip, dns.RcodeToString[r.Rcode], probe)
+				res.Information = append(res.Information, msg)
+			default:
+				msg := fmt.Sprintf("%v does not recurse, answered %v for %v", ip, dns.RcodeToString[r.Rcode], probe)
+				res.Information = append(res.Information, msg)
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *RecursionCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// recursionProbe returns a name that the nameservers of zone are not
+// authoritative for
+func recursionProbe(zone string) string {
+	for _, p := range defaults.RecursionProbes {
+		if !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(p)) {
+			return p
+		}
+	}
+	return defaults.RecursionProbes[0]
+}
@@ -3,112 +3,147 @@ package dnschecks
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
-	"github.com/5amu/dnshunter/pkg/defaults"
 	"github.com/5amu/dnshunter/pkg/output"
 	"github.com/5amu/dnshunter/pkg/utils"
 	"github.com/miekg/dns"
 )
 
 type SOACheck struct {
+	// Profile is the name of a built-in SOA timer profile or the path of a
+	// YAML profile, RIPE-203 is used when empty
+	Profile string
+
 	description []string
+	profile     *SOAProfile
 	client      *dns.Client
 	output      *output.CheckOutput
 }
 
-func (c *SOACheck) Init(client *dns.Client) error {
+func (c *SOACheck) Init(client *dns.Client) (err error) {
 	c.client = client
 	c.description = []string{
-		"SOA record should follow RIPE-203 standard",
+		"SOA record should follow RIPE-203 standard and be the same on every",
+		"authoritative nameserver, a serial that differs between servers means",
+		"that secondaries are not in sync with the primary.",
 		"more info at https://www.ripe.net/publications/docs/ripe-203",
 	}
-	return nil
+	c.profile, err = LoadSOAProfile(c.Profile)
+	return err
 }
 
 func (c *SOACheck) Start(domain string, nameservers *utils.Nameservers) error {
-	var res output.SingleCheckResult
-	res.Nameserver = defaults.DefaultNameserver
-	res.Zone = domain
 	c.output = &output.CheckOutput{
 		Name:        "SOA Record",
 		Domain:      domain,
-		Nameservers: []string{defaults.DefaultNameserver},
+		Nameservers: nameservers.FQDNs,
 		Description: c.description,
 	}
 
-	r, err := utils.MakeQuery(
-		c.client,
-		dns.Fqdn(domain),
-		net.JoinHostPort(defaults.DefaultNameserver, "53"),
-		dns.TypeSOA,
-	)
-	if err != nil {
-		return err
-	}
+	// The SOA is asked to every authoritative address, the records are kept
+	// aside to compare them once all the servers answered
+	var soas []*dns.SOA
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
 
-	for _, soa := range r.Answer {
-		switch t := soa.(type) {
-		case *dns.SOA:
-			res.Information = append(res.Information, fmt.Sprintf("Zone name: %v", t.Ns))
-			res.Information = append(res.Information, fmt.Sprintf("Start of Authority: %v", t.Mbox))
-			res.Information = append(res.Information, parseSerial(fmt.Sprint(t.Serial), &res.Vulnerable))
-			res.Information = append(res.Information, parseRefresh(fmt.Sprint(t.Refresh), &res.Vulnerable))
-			res.Information = append(res.Information, parseRetry(fmt.Sprint(t.Retry), &res.Vulnerable))
-			res.Information = append(res.Information, parseExpire(fmt.Sprint(t.Expire), &res.Vulnerable))
-			res.Information = append(res.Information, fmt.Sprintf("TTL: %v", t.Hdr.Ttl))
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				nameservers.Zone,
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeSOA,
+			)
+			if err == nil && r.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+			}
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				soas = append(soas, nil)
+				continue
+			}
+
+			var soa *dns.SOA
+			for _, a := range r.Answer {
+				if t, ok := a.(*dns.SOA); ok {
+					soa = t
+				}
+			}
+			if soa == nil {
+				res.Information = append(res.Information, "no SOA record in the answer")
+			} else {
+				res.Information = append(res.Information, fmt.Sprintf("Primary nameserver (MNAME): %v", soa.Ns))
+				res.Information = append(res.Information, fmt.Sprintf("Responsible mailbox (RNAME): %v", soa.Mbox))
+				res.Information = append(res.Information, parseSerial(soa.Serial, time.Now(), &res.Vulnerable)...)
+				res.Information = append(res.Information, c.profile.Evaluate(soa, &res.Vulnerable)...)
+				res.Information = append(res.Information, fmt.Sprintf("TTL: %v", soa.Hdr.Ttl))
+			}
+			c.output.Results = append(c.output.Results, res)
+			soas = append(soas, soa)
 		}
 	}
-	c.output.Results = append(c.output.Results, res)
-	return nil
-}
 
-func (c *SOACheck) Results() *output.CheckOutput {
-	return c.output
+	c.compareSOAs(soas)
+	return nil
 }
 
-func parseSerial(serial string, isVuln *bool) string {
-	if len(serial) < 8 {
-		return fmt.Sprintf("Serial number: %v - should follow standards (RIPE-203)\n", serial)
+// compareSOAs reports the servers whose SOA differs from the one of the
+// primary: the server named in MNAME when it answered, otherwise the most
+// recent serial is taken as reference. soas[i] is the answer of Results[i]
+func (c *SOACheck) compareSOAs(soas []*dns.SOA) {
+	var ref *dns.SOA
+	for i, soa := range soas {
+		if soa == nil {
+			continue
+		}
+		if strings.EqualFold(c.output.Results[i].Nameserver, strings.Trim(soa.Ns, ".")) {
+			ref = soa
+			break
+		}
+		if ref == nil || serialLess(ref.Serial, soa.Serial) {
+			ref = soa
+		}
 	}
-	serialDate, _ := time.Parse("%Y%m%d", string(serial)[:7])
-	dummyLower, _ := time.Parse("%s", "0")
-	dummyUpper, _ := time.Parse("%s", fmt.Sprintf("%v", time.Now().Unix()))
-	if serialDate.After(dummyUpper) || serialDate.Before(dummyLower) {
-		*isVuln = true
-		return fmt.Sprintf("Serial number: %v - should follow standards (RIPE-203)", serial)
-	} else {
-		return fmt.Sprintf("Serial number: %v", serial)
+	if ref == nil {
+		return
 	}
-}
 
-func parseRefresh(refresh string, isVuln *bool) string {
-	r, _ := time.ParseDuration(fmt.Sprintf("%vs", refresh))
-	if r < (24 * time.Hour) {
-		*isVuln = true
-		return fmt.Sprintf("Refresh: %v - should follow standards (RIPE-203)", refresh)
-	} else {
-		return fmt.Sprintf("Refresh: %v", refresh)
+	for i, soa := range soas {
+		if soa == nil {
+			continue
+		}
+		res := &c.output.Results[i]
+		if serialLess(soa.Serial, ref.Serial) {
+			res.Vulnerable = true
+			msg := fmt.Sprintf("serial %d lags behind the primary serial %d, the zone is not in sync", soa.Serial, ref.Serial)
+			res.Information = append(res.Information, msg)
+		} else if soa.Serial != ref.Serial {
+			res.Vulnerable = true
+			msg := fmt.Sprintf("serial %d differs from the primary serial %d", soa.Serial, ref.Serial)
+			res.Information = append(res.Information, msg)
+		}
+		if !strings.EqualFold(soa.Ns, ref.Ns) {
+			res.Vulnerable = true
+			res.Information = append(res.Information, fmt.Sprintf("MNAME %v differs from the primary MNAME %v", soa.Ns, ref.Ns))
+		}
+		if !strings.EqualFold(soa.Mbox, ref.Mbox) {
+			res.Vulnerable = true
+			res.Information = append(res.Information, fmt.Sprintf("RNAME %v differs from the primary RNAME %v", soa.Mbox, ref.Mbox))
+		}
 	}
 }
 
-func parseRetry(retry string, isVuln *bool) string {
-	r, _ := time.ParseDuration(fmt.Sprintf("%vs", retry))
-	if r < (2 * time.Hour) {
-		*isVuln = true
-		return fmt.Sprintf("Retry: %v - should follow standards (RIPE-203)", retry)
-	} else {
-		return fmt.Sprintf("Retry: %v", retry)
-	}
+func (c *SOACheck) Results() *output.CheckOutput {
+	return c.output
 }
 
-func parseExpire(expire string, isVuln *bool) string {
-	e, _ := time.ParseDuration(fmt.Sprintf("%vs", expire))
-
-	if e < (1000 * time.Hour) {
-		*isVuln = true
-		return fmt.Sprintf("Expire: %v - should follow standards (RIPE-203)", expire)
-	} else {
-		return fmt.Sprintf("Expire: %v", expire)
-	}
+// serialLess compares SOA serials using the serial number arithmetic of
+// RFC 1982, so that a serial that wrapped around is still seen as newer
+func serialLess(s1, s2 uint32) bool {
+	return s1 != s2 && int32(s2-s1) > 0
 }
@@ -0,0 +1,116 @@
+package dnschecks
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSOAProfile is the profile used when none is selected
+const DefaultSOAProfile = "ripe-203"
+
+// TimerBounds is the accepted range of a SOA timer in seconds, a zero
+// bound is not enforced
+type TimerBounds struct {
+	Min uint32 `yaml:"min"`
+	Max uint32 `yaml:"max"`
+}
+
+// SOAProfile is a policy the SOA timers are evaluated against
+type SOAProfile struct {
+	Name    string      `yaml:"name"`
+	Refresh TimerBounds `yaml:"refresh"`
+	Retry   TimerBounds `yaml:"retry"`
+	Expire  TimerBounds `yaml:"expire"`
+	Minimum TimerBounds `yaml:"minimum"`
+}
+
+// SOAProfiles are the built-in profiles, selectable by name
+var SOAProfiles = map[string]*SOAProfile{
+	// https://www.ripe.net/publications/docs/ripe-203, the recommended
+	// values are used as lower bounds and the 2 days negative caching as
+	// the upper bound of MINIMUM
+	"ripe-203": {
+		Name:    "RIPE-203",
+		Refresh: TimerBounds{Min: 86400},
+		Retry:   TimerBounds{Min: 7200},
+		Expire:  TimerBounds{Min: 3600000},
+		Minimum: TimerBounds{Min: 300, Max: 172800},
+	},
+	// https://www.rfc-editor.org/rfc/rfc1912#section-2.2
+	"rfc1912": {
+		Name:    "RFC 1912",
+		Refresh: TimerBounds{Min: 1200, Max: 43200},
+		Retry:   TimerBounds{Min: 180, Max: 43200},
+		Expire:  TimerBounds{Min: 1209600, Max: 2419200},
+		Minimum: TimerBounds{Min: 86400, Max: 432000},
+	},
+}
+
+// LoadSOAProfile returns the built-in profile with the given name, any other
+// value is read as the path of a YAML profile
+func LoadSOAProfile(name string) (*SOAProfile, error) {
+	if name == "" {
+		name = DefaultSOAProfile
+	}
+	if p, ok := SOAProfiles[strings.ToLower(name)]; ok {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown SOA profile %v: %v", name, err)
+	}
+	p := new(SOAProfile)
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid SOA profile %v: %v", name, err)
+	}
+	for _, b := range []TimerBounds{p.Refresh, p.Retry, p.Expire, p.Minimum} {
+		if b.Max != 0 && b.Min > b.Max {
+			return nil, fmt.Errorf("invalid SOA profile %v: min %d is greater than max %d", name, b.Min, b.Max)
+		}
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	return p, nil
+}
+
+// Evaluate checks the SOA timers against the profile and the relations
+// between them, one line is returned for each timer
+func (p *SOAProfile) Evaluate(soa *dns.SOA, isVuln *bool) []string {
+	info := []string{
+		p.evaluateTimer("Refresh", soa.Refresh, p.Refresh, isVuln),
+		p.evaluateTimer("Retry", soa.Retry, p.Retry, isVuln),
+		p.evaluateTimer("Expire", soa.Expire, p.Expire, isVuln),
+		p.evaluateTimer("Minimum (negative caching)", soa.Minttl, p.Minimum, isVuln),
+	}
+
+	// secondaries would retry a failed transfer after the next refresh
+	// anyway, and would expire the zone before having tried to refresh it
+	if soa.Retry >= soa.Refresh {
+		*isVuln = true
+		info = append(info, fmt.Sprintf("Retry %d should be lower than Refresh %d", soa.Retry, soa.Refresh))
+	}
+	if uint64(soa.Expire) <= uint64(soa.Refresh)+uint64(soa.Retry) {
+		*isVuln = true
+		msg := fmt.Sprintf("Expire %d should be greater than Refresh + Retry (%d)", soa.Expire, uint64(soa.Refresh)+uint64(soa.Retry))
+		info = append(info, msg)
+	}
+	return info
+}
+
+func (p *SOAProfile) evaluateTimer(name string, value uint32, b TimerBounds, isVuln *bool) string {
+	if b.Min != 0 && value < b.Min {
+		*isVuln = true
+		return fmt.Sprintf("%v: %d - below the minimum of %d (%v)", name, value, b.Min, p.Name)
+	}
+	if b.Max != 0 && value > b.Max {
+		*isVuln = true
+		return fmt.Sprintf("%v: %d - above the maximum of %d (%v)", name, value, b.Max, p.Name)
+	}
+	return fmt.Sprintf("%v: %d", name, value)
+}
@@ -0,0 +1,92 @@
+package dnschecks
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Numbering schemes used for the SOA serial
+const (
+	SerialDate    = "date (YYYYMMDDnn)"
+	SerialUnix    = "Unix timestamp"
+	SerialCounter = "plain counter"
+)
+
+// oldestSerialDate is the lower bound for plausible dates in a serial, both
+// in the YYYYMMDDnn and Unix timestamp schemes
+var oldestSerialDate = time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// serialAnalysis is the outcome of the inspection of a SOA serial
+type serialAnalysis struct {
+	Scheme   string
+	Date     time.Time
+	Findings []string
+}
+
+// analyzeSerial detects the numbering scheme of the serial and validates the
+// date it carries (if any) against now. RIPE-203 recommends the YYYYMMDDnn
+// syntax, the revision nn allowing 100 changes a day
+func analyzeSerial(serial uint32, now time.Time) *serialAnalysis {
+	a := &serialAnalysis{Scheme: SerialCounter}
+	digits := strconv.FormatUint(uint64(serial), 10)
+	recommended := now.Format("20060102") + "00"
+	tomorrow := now.Add(24 * time.Hour)
+
+	looksLikeDate := len(digits) == 10 && (digits[:2] == "19" || digits[:2] == "20")
+	if looksLikeDate {
+		if date, err := time.Parse("20060102", digits[:8]); err == nil {
+			a.Scheme = SerialDate
+			a.Date = date
+			if date.Before(oldestSerialDate) {
+				a.Findings = append(a.Findings, fmt.Sprintf(
+					"the date %v is implausibly old, the serial was probably not meant to follow "+
+						"the YYYYMMDDnn syntax; recommended value %v",
+					date.Format("2006-01-02"), recommended))
+			}
+			if date.After(tomorrow) {
+				a.Findings = append(a.Findings, fmt.Sprintf(
+					"the date %v is in the future: secondaries will refuse any lower serial, so going back "+
+						"requires serial number arithmetic (RFC 1982) to wrap around; recommended value %v",
+					date.Format("2006-01-02"), recommended))
+			}
+			return a
+		}
+	}
+
+	// only past timestamps are told apart from counters, large counters are
+	// common and would otherwise look like timestamps in the future
+	unix := time.Unix(int64(serial), 0).UTC()
+	if len(digits) >= 9 && !unix.Before(oldestSerialDate) && !unix.After(tomorrow) {
+		a.Scheme = SerialUnix
+		a.Date = unix
+		return a
+	}
+
+	if looksLikeDate {
+		a.Findings = append(a.Findings, fmt.Sprintf(
+			"%v looks like YYYYMMDDnn but does not carry a valid date; "+
+				"RIPE-203 recommends the YYYYMMDDnn syntax, e.g. %v", digits, recommended))
+		return a
+	}
+
+	a.Findings = append(a.Findings, fmt.Sprintf(
+		"the serial is a plain counter, it tells nothing about when the zone was last changed; "+
+			"RIPE-203 recommends the YYYYMMDDnn syntax, e.g. %v", recommended))
+	return a
+}
+
+// parseSerial reports the serial, its numbering scheme and every problem
+// found by analyzeSerial
+func parseSerial(serial uint32, now time.Time, isVuln *bool) []string {
+	a := analyzeSerial(serial, now)
+	msgs := []string{fmt.Sprintf("Serial number: %v (%v)", serial, a.Scheme)}
+	if !a.Date.IsZero() {
+		msgs = append(msgs, fmt.Sprintf("Serial date: %v", a.Date.Format("2006-01-02")))
+	}
+	for _, f := range a.Findings {
+		*isVuln = true
+		msgs = append(msgs, fmt.Sprintf("Serial number: %v - %v (RIPE-203)", serial, f))
+	}
+	return msgs
+}
@@ -0,0 +1,127 @@
+package dnschecks
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeSerial(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		serial   uint32
+		scheme   string
+		date     string
+		findings []string
+	}{
+		{
+			name:   "valid date",
+			serial: 2024061501,
+			scheme: SerialDate,
+			date:   "2024-06-15",
+		},
+		{
+			name:     "future date",
+			serial:   2030010100,
+			scheme:   SerialDate,
+			date:     "2030-01-01",
+			findings: []string{"the date 2030-01-01 is in the future"},
+		},
+		{
+			name:     "date before 1990",
+			serial:   1980010100,
+			scheme:   SerialDate,
+			date:     "1980-01-01",
+			findings: []string{"the date 1980-01-01 is implausibly old"},
+		},
+		{
+			name:     "invalid calendar date",
+			serial:   2024023099,
+			scheme:   SerialCounter,
+			findings: []string{"2024023099 looks like YYYYMMDDnn but does not carry a valid date"},
+		},
+		{
+			name:   "unix timestamp",
+			serial: 1718000000,
+			scheme: SerialUnix,
+			date:   "2024-06-10",
+		},
+		{
+			name:     "plain counter",
+			serial:   42,
+			scheme:   SerialCounter,
+			findings: []string{"the serial is a plain counter"},
+		},
+		{
+			name:     "zero",
+			serial:   0,
+			scheme:   SerialCounter,
+			findings: []string{"the serial is a plain counter"},
+		},
+		{
+			name:     "large counter",
+			serial:   2300000000,
+			scheme:   SerialCounter,
+			findings: []string{"the serial is a plain counter"},
+		},
+		{
+			name:     "maximum serial",
+			serial:   4294967295,
+			scheme:   SerialCounter,
+			findings: []string{"the serial is a plain counter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := analyzeSerial(tt.serial, now)
+			if a.Scheme != tt.scheme {
+				t.Errorf("scheme = %q, want %q", a.Scheme, tt.scheme)
+			}
+			var date string
+			if !a.Date.IsZero() {
+				date = a.Date.Format("2006-01-02")
+			}
+			if date != tt.date {
+				t.Errorf("date = %q, want %q", date, tt.date)
+			}
+			if len(a.Findings) != len(tt.findings) {
+				t.Fatalf("findings = %q, want %d findings", a.Findings, len(tt.findings))
+			}
+			for i, f := range tt.findings {
+				if !strings.HasPrefix(a.Findings[i], f) {
+					t.Errorf("finding %d = %q, want prefix %q", i, a.Findings[i], f)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSerial(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		serial uint32
+		vuln   bool
+		msgs   int
+	}{
+		{serial: 2024061501, vuln: false, msgs: 2},
+		{serial: 2030010100, vuln: true, msgs: 3},
+		{serial: 2024023099, vuln: true, msgs: 2},
+		{serial: 1718000000, vuln: false, msgs: 2},
+		{serial: 0, vuln: true, msgs: 2},
+	}
+
+	for _, tt := range tests {
+		var vuln bool
+		msgs := parseSerial(tt.serial, now, &vuln)
+		if vuln != tt.vuln {
+			t.Errorf("parseSerial(%v): vulnerable = %v, want %v", tt.serial, vuln, tt.vuln)
+		}
+		if len(msgs) != tt.msgs {
+			t.Errorf("parseSerial(%v) = %q, want %d messages", tt.serial, msgs, tt.msgs)
+		}
+	}
+}
@@ -3,9 +3,10 @@ package dnschecks
 import (
 	"fmt"
 	"net"
-	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/5amu/dnshunter/pkg/defaults"
 	"github.com/5amu/dnshunter/pkg/output"
 	"github.com/5amu/dnshunter/pkg/utils"
 	"github.com/miekg/dns"
@@ -15,21 +16,35 @@ type SPFCheck struct {
 	description []string
 	client      *dns.Client
 	output      *output.CheckOutput
-	currentNS   string
+	resolver    *utils.Resolver
 }
 
 func (c *SPFCheck) Init(client *dns.Client) error {
 	c.client = client
 	c.description = []string{
 		"SPF is a TXT record that prevents mail spoofing by verifying servers",
-		"that are allowed to send emails using the specified domain. To better",
-		"understand the syntax, refer to this link: https://dmarcian.com/spf-syntax-table/",
+		"that are allowed to send emails using the specified domain. The record",
+		"is evaluated as defined by RFC 7208 for a spoofed sender, with the",
+		"limits on DNS lookups enforced by receivers.",
+		"The include tree is then flattened into the networks allowed to send",
+		"as the domain, to spot ranges that authorize far more senders than",
+		"the organization owns, like whole shared provider platforms that",
+		"let every customer of the provider pass SPF for the domain.",
+		"To better understand the syntax, refer to this link:",
+		"https://dmarcian.com/spf-syntax-table/",
 	}
 	return nil
 }
 
+// recordEvaluation is the outcome of the evaluation of a mail policy record,
+// shared by the nameservers serving the same record
+type recordEvaluation struct {
+	info       []string
+	evidence   []string
+	vulnerable bool
+}
+
 func (c *SPFCheck) Start(domain string, nameservers *utils.Nameservers) error {
-	var spfRecords []string
 	c.output = &output.CheckOutput{
 		Name:        "SPF Record",
 		Domain:      domain,
@@ -37,27 +52,41 @@ func (c *SPFCheck) Start(domain string, nameservers *utils.Nameservers) error {
 		Description: c.description,
 	}
 
+	c.resolver = nameservers.Resolver
+	evaluations := make(map[string]*recordEvaluation)
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
-
-		c.currentNS = nameservers.GetIP(fqdn).String()
-		if spf := c.getSPF(domain); spf != "" {
-			var present bool
-			for _, v := range spfRecords {
-				if v == spf {
-					present = true
-					break
-				}
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = domain
+
+			r, err := utils.MakeNonRecursiveQuery(
+				c.client,
+				dns.Fqdn(domain),
+				net.JoinHostPort(ip.String(), "53"),
+				dns.TypeTXT,
+			)
+			if err == nil && r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+				err = fmt.Errorf("%v answered %v", ip, dns.RcodeToString[r.Rcode])
+			}
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
 			}
 
-			if !present {
-				spfRecords = append(spfRecords, spf)
-				res.Information = c.recursiveSPFCheck(spf, domain, []string{}, "", 0, &res.Vulnerable)
+			// every TXT record takes part in the key, since publishing
+			// more than one SPF record is an error by itself
+			key := rdataSet(r.Answer)
+			if _, ok := evaluations[key]; !ok {
+				evaluations[key] = c.evaluate(domain, r.Answer)
 			}
+			res.Information = evaluations[key].info
+			res.Evidence = evaluations[key].evidence
+			res.Vulnerable = evaluations[key].vulnerable
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
@@ -66,59 +95,78 @@ func (c *SPFCheck) Results() *output.CheckOutput {
 	return c.output
 }
 
-func (c *SPFCheck) recursiveSPFCheck(record string, domain string, message []string, spacing string, depth int, isVuln *bool) []string {
-	stop := false
-
-	if depth == 3 {
-		return nil
+// evaluate runs check_host() against the SPF record among the TXT records
+// of the domain, for mail coming from an address no policy should authorize
+func (c *SPFCheck) evaluate(domain string, txt []dns.RR) *recordEvaluation {
+	ev := new(recordEvaluation)
+	record, err := utils.SelectSPF(txt)
+	if err != nil {
+		ev.vulnerable = true
+		ev.info = append(ev.info, fmt.Sprintf("%v: %v (%v)", domain, err, utils.SPFPermError))
+		return ev
 	}
-
 	if record == "" {
-		message = append(message, fmt.Sprintf("%vNo SPF for %v", spacing, domain))
-	} else if strings.Contains(record, "-all") {
-		message = append(message, fmt.Sprintf("%vSecure (-all) SPF for %v", spacing, domain))
-		stop = true
-	} else if strings.Contains(record, "~all") {
-		*isVuln = true
-		message = append(message, fmt.Sprintf("%vPartially secure (~all) SPF for %v", spacing, domain))
-	} else if strings.Contains(record, "+all") {
-		*isVuln = true
-		message = append(message, fmt.Sprintf("%vInsecure (+all) SPF for %v", spacing, domain))
-	} else if strings.Contains(record, "?all") {
-		*isVuln = true
-		message = append(message, fmt.Sprintf("%vInsecure (?all) SPF for %v", spacing, domain))
+		ev.vulnerable = true
+		ev.info = append(ev.info, fmt.Sprintf("No SPF for %v", domain))
+		return ev
 	}
 
-	if !stop {
-		r := regexp.MustCompile(`(redirect=|include:)[^ \"]*`)
-		for _, d := range r.FindAllString(record, -1) {
-			d := strings.Split(d, ":")[1]
-			spf := c.getSPF(d)
-			message = append(message, c.recursiveSPFCheck(spf, d, message, spacing+"    ", depth+1, isVuln)...)
-		}
+	probe := net.ParseIP(defaults.SPFProbeAddress)
+	e := utils.NewSPFEvaluator(c.resolver, probe, "postmaster@"+domain, domain)
+	result, err := e.CheckRecord(record, domain)
+
+	ev.info = append(ev.info, e.Trace...)
+	ev.info = append(ev.info, fmt.Sprintf("result for mail sent from %v: %v", probe, result))
+	if result == utils.SPFTempError {
+		ev.info = append(ev.info, fmt.Sprintf("evaluation incomplete: %v", err))
 	}
-	return message
-}
+	ev.info = append(ev.info, fmt.Sprintf("DNS lookups: %d (limit 10), void lookups: %d (limit 2)", e.Lookups, e.Voids))
+	ev.info = append(ev.info, e.Findings...)
+	ev.vulnerable = len(e.Findings) > 0 || (result != utils.SPFFail && result != utils.SPFTempError)
 
-func (c *SPFCheck) getSPF(domain string) string {
+	findings := c.flatten(ev, record, domain)
+	ev.vulnerable = ev.vulnerable || findings > 0
+	return ev
+}
 
-	r, err := utils.MakeQuery(
-		c.client,
-		dns.Fqdn(domain),
-		net.JoinHostPort(c.currentNS, "53"),
-		dns.TypeTXT,
-	)
-	if err != nil {
-		return ""
+// flatten lists the networks authorized by the record with the include chain
+// that authorized them, it returns the number of overly permissive ones
+func (c *SPFCheck) flatten(ev *recordEvaluation, record, domain string) int {
+	flat := utils.FlattenSPF(c.resolver, record, domain)
+	v4, v6 := utils.CountAddresses(flat.Prefixes)
+	ev.info = append(ev.info, fmt.Sprintf("authorized senders: %d prefixes, %v IPv4 and %v IPv6 addresses", len(flat.Prefixes), v4, v6))
+
+	var findings int
+	for _, p := range flat.Prefixes {
+		ev.evidence = append(ev.evidence, p.String())
+		ones, bits := p.Prefix.Mask.Size()
+		if (bits == 32 && ones < defaults.SPFBroadIPv4Prefix) || (bits == 128 && ones < defaults.SPFBroadIPv6Prefix) {
+			findings++
+			ev.info = append(ev.info, fmt.Sprintf("overly permissive range: %v", p))
+		}
 	}
 
-	for _, spf := range r.Answer {
-		switch t := spf.(type) {
-		case *dns.TXT:
-			if strings.Contains(t.Txt[0], "v=spf") {
-				return t.Txt[0]
-			}
+	var includes []string
+	for include := range flat.Includes {
+		includes = append(includes, include)
+	}
+	sort.Strings(includes)
+	for _, include := range includes {
+		provider, ok := defaults.SharedSPFIncludes[include]
+		if !ok {
+			continue
 		}
+		findings++
+		v4, v6 := utils.CountAddresses(flat.Via(include))
+		msg := fmt.Sprintf(
+			"overly permissive shared include: %v (%v) authorizes %v IPv4 and %v IPv6 addresses of every %v customer, only DKIM and DMARC alignment tell them apart",
+			include, strings.Join(flat.Includes[include], " > "), v4, v6, provider,
+		)
+		ev.info = append(ev.info, msg)
+	}
+
+	for _, u := range flat.Unresolved {
+		ev.info = append(ev.info, fmt.Sprintf("not flattened: %v", u))
 	}
-	return ""
+	return findings
 }
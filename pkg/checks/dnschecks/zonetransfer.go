@@ -36,48 +36,51 @@ func (c *AXFRCheck) Start(domain string, nameservers *utils.Nameservers) error {
 	}
 
 	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeAXFR)
+	m.SetQuestion(dns.Fqdn(nameservers.Zone), dns.TypeAXFR)
 
 	for _, fqdn := range nameservers.FQDNs {
-		var res output.SingleCheckResult
-		res.Nameserver = fqdn
-		res.Zone = domain
-		res.Vulnerable = false
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+			res.Vulnerable = false
 
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(nameservers.GetIP(fqdn).String(), "53"), 2*time.Second)
-		if err != nil {
-			continue
-		}
-		transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}}
-		channel, err := transfer.In(m, nameservers.GetIP(fqdn).String())
-		if err != nil {
-			continue
-		}
-
-		var vuln []dns.RR
-		for r := range channel {
-			if r.Error != nil || len(r.RR) == 0 {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), "53"), 2*time.Second)
+			if err != nil {
 				continue
 			}
-			res.Vulnerable = true
-			vuln = append(vuln, r.RR...)
-		}
+			transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}}
+			channel, err := transfer.In(m, ip.String())
+			if err != nil {
+				continue
+			}
+
+			var vuln []dns.RR
+			for r := range channel {
+				if r.Error != nil || len(r.RR) == 0 {
+					continue
+				}
+				res.Vulnerable = true
+				vuln = append(vuln, r.RR...)
+			}
 
-		if res.Vulnerable {
-			for _, v := range vuln {
-				switch t := v.(type) {
-				case *dns.A:
-					res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "A", t.A))
-				case *dns.AAAA:
-					res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "AAAA", t.AAAA))
-				case *dns.TXT:
-					res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "TXT", t.Txt[0]))
-				case *dns.MX:
-					res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "MX", t.Mx))
+			if res.Vulnerable {
+				for _, v := range vuln {
+					switch t := v.(type) {
+					case *dns.A:
+						res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "A", t.A))
+					case *dns.AAAA:
+						res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "AAAA", t.AAAA))
+					case *dns.TXT:
+						res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "TXT", t.Txt[0]))
+					case *dns.MX:
+						res.Information = append(res.Information, fmt.Sprintf("%v ==> (%v) %v\n", t.Hdr.Name, "MX", t.Mx))
+					}
 				}
 			}
+			c.output.Results = append(c.output.Results, res)
 		}
-		c.output.Results = append(c.output.Results, res)
 	}
 	return nil
 }
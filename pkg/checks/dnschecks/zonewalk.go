@@ -0,0 +1,221 @@
+package dnschecks
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/5amu/dnshunter/pkg/output"
+	"github.com/5amu/dnshunter/pkg/utils"
+	"github.com/miekg/dns"
+)
+
+// maxNSEC3Iterations is the number of iterations above which validating
+// resolvers may treat the zone as insecure (RFC 9276 section 3.2)
+const maxNSEC3Iterations = 100
+
+// errSynthesizedNSEC is returned by walk when the server makes up the NSEC
+// records on the fly instead of serving the chain of the zone
+var errSynthesizedNSEC = errors.New("synthesized NSEC records")
+
+type ZoneWalkCheck struct {
+	// Limit is the maximum number of names enumerated from the NSEC chain,
+	// defaults.ZoneWalkLimit is used when zero
+	Limit int
+
+	description []string
+	poc         string
+	client      *dns.Client
+	output      *output.CheckOutput
+}
+
+func (c *ZoneWalkCheck) Init(client *dns.Client) error {
+	c.client = client
+	c.description = []string{
+		"Zones signed with plain NSEC records can be enumerated by following",
+		"the chain of NSEC records, each one pointing to the next name in the",
+		"zone. NSEC3 hashes the names, but RFC 9276 recommends no additional",
+		"iterations, an empty salt and no opt-out since they only make",
+		"validation more expensive without adding protection.",
+		"More info at https://www.rfc-editor.org/rfc/rfc9276",
+	}
+	c.poc = "PoC: dig -t NSEC +dnssec +norecurse %v @%v"
+	if c.Limit == 0 {
+		c.Limit = defaults.ZoneWalkLimit
+	}
+	if c.Limit < 0 {
+		return fmt.Errorf("invalid zone walk limit: %v", c.Limit)
+	}
+	return nil
+}
+
+func (c *ZoneWalkCheck) Start(domain string, nameservers *utils.Nameservers) error {
+	c.output = &output.CheckOutput{
+		Name:        "Zone Enumeration (NSEC/NSEC3)",
+		Domain:      domain,
+		Nameservers: nameservers.FQDNs,
+		Description: c.description,
+	}
+
+	apex := dns.Fqdn(nameservers.Zone)
+	for _, fqdn := range nameservers.FQDNs {
+		for _, ip := range nameservers.GetIPs(fqdn) {
+			var res output.SingleCheckResult
+			res.Nameserver = fqdn
+			res.Address = ip.String()
+			res.Zone = nameservers.Zone
+			server := net.JoinHostPort(ip.String(), "53")
+
+			// a name that does not exist makes the server prove its
+			// absence with the denial of existence records in use
+			probe := fmt.Sprintf("dnshunter-%08x.%v", rand.Uint32(), apex)
+			r, err := utils.MakeDNSSECQuery(c.client, probe, server, dns.TypeA)
+			if err != nil {
+				res.Information = append(res.Information, fmt.Sprintf("query failed: %v", err))
+				c.output.Results = append(c.output.Results, res)
+				continue
+			}
+
+			var nsec bool
+			var nsec3 []*dns.NSEC3
+			for _, rr := range r.Ns {
+				switch t := rr.(type) {
+				case *dns.NSEC:
+					nsec = true
+				case *dns.NSEC3:
+					nsec3 = append(nsec3, t)
+				}
+			}
+
+			switch {
+			case nsec:
+				names, complete, err := c.walk(apex, server)
+				if errors.Is(err, errSynthesizedNSEC) {
+					msg := "the zone uses minimal or synthesized NSEC records (RFC 4470), its names can't be enumerated"
+					res.Information = append(res.Information, msg)
+					break
+				}
+				res.Vulnerable = len(names) > 1
+				res.Evidence = names
+				msg := fmt.Sprintf("the zone uses NSEC, %d names enumerated by walking the chain", len(names))
+				if !complete {
+					msg += fmt.Sprintf(" (stopped at the limit of %d)", c.Limit)
+				}
+				if err != nil {
+					msg = fmt.Sprintf("the zone uses NSEC, the walk stopped after %d names: %v", len(names), err)
+				}
+				res.Information = append(res.Information, msg)
+				res.Information = append(res.Information, fmt.Sprintf(c.poc, apex, ip))
+			case len(nsec3) > 0:
+				c.auditNSEC3(&res, apex, ip, nsec3)
+			default:
+				res.Information = append(res.Information, "no NSEC or NSEC3 record in the negative answer, the zone is probably not signed")
+			}
+			c.output.Results = append(c.output.Results, res)
+		}
+	}
+	return nil
+}
+
+func (c *ZoneWalkCheck) Results() *output.CheckOutput {
+	return c.output
+}
+
+// walk follows the NSEC chain from the apex until it wraps around or the
+// limit is reached, it tells whether the whole chain has been walked.
+// errSynthesizedNSEC is returned for online signers making up the next name
+func (c *ZoneWalkCheck) walk(apex, server string) ([]string, bool, error) {
+	names := []string{strings.TrimSuffix(apex, ".")}
+	seen := map[string]bool{strings.ToLower(apex): true}
+	name := apex
+	for len(names) < c.Limit {
+		next, err := c.nextName(name, server)
+		if err != nil {
+			return names, false, err
+		}
+		if synthesizedNSEC(next) {
+			return names, false, errSynthesizedNSEC
+		}
+		if seen[strings.ToLower(next)] || !dns.IsSubDomain(apex, next) {
+			return names, true, nil
+		}
+		seen[strings.ToLower(next)] = true
+		names = append(names, strings.TrimSuffix(next, "."))
+		name = next
+	}
+	return names, false, nil
+}
+
+// synthesizedNSEC tells if next is made up rather than the next name of the
+// zone: online signers answer with the immediate successor of the name, the
+// name itself prefixed by a \000 label (RFC 4470 section 3.1.2). A child of
+// name is not enough, the apex is commonly followed by one of its children
+func synthesizedNSEC(next string) bool {
+	labels := dns.SplitDomainName(next)
+	return len(labels) > 0 && labels[0] == "\\000"
+}
+
+// nextName returns the name following name in the NSEC chain, asking for the
+// NSEC record of name and, for servers that refuse such queries, for the name
+// right after it, whose denial carries the NSEC record of name
+func (c *ZoneWalkCheck) nextName(name, server string) (string, error) {
+	for _, q := range []struct {
+		name  string
+		qType uint16
+	}{
+		{name, dns.TypeNSEC},
+		{"\\000." + name, dns.TypeA},
+	} {
+		r, err := utils.MakeDNSSECQuery(c.client, q.name, server, q.qType)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range append(r.Answer, r.Ns...) {
+			if t, ok := rr.(*dns.NSEC); ok && strings.EqualFold(t.Hdr.Name, name) {
+				return t.NextDomain, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no NSEC record found for %v", name)
+}
+
+// auditNSEC3 reports the NSEC3 parameters that deviate from RFC 9276
+func (c *ZoneWalkCheck) auditNSEC3(res *output.SingleCheckResult, apex string, ip net.IP, records []*dns.NSEC3) {
+	iterations, salt := records[0].Iterations, records[0].Salt
+	if r, err := utils.MakeDNSSECQuery(c.client, apex, net.JoinHostPort(ip.String(), "53"), dns.TypeNSEC3PARAM); err == nil {
+		for _, rr := range utils.ExtractRRset(r.Answer, apex, dns.TypeNSEC3PARAM) {
+			param := rr.(*dns.NSEC3PARAM)
+			iterations, salt = param.Iterations, param.Salt
+		}
+	}
+	// the salt is "-" in presentation format when empty
+	saltLen := len(salt) / 2
+	if salt == "-" {
+		saltLen = 0
+	}
+
+	res.Information = append(res.Information, fmt.Sprintf("the zone uses NSEC3 with %d iterations and a %d bytes salt", iterations, saltLen))
+	if iterations > 0 {
+		res.Vulnerable = true
+		msg := fmt.Sprintf("iterations: %d - RFC 9276 recommends 0 additional iterations", iterations)
+		if iterations > maxNSEC3Iterations {
+			msg += fmt.Sprintf(", above %d validating resolvers may treat the zone as insecure", maxNSEC3Iterations)
+		}
+		res.Information = append(res.Information, msg)
+	}
+	if saltLen > 0 {
+		res.Vulnerable = true
+		res.Information = append(res.Information, fmt.Sprintf("salt: %v - RFC 9276 recommends an empty salt", salt))
+	}
+	for _, r := range records {
+		if r.Flags&1 == 1 {
+			msg := "opt-out is set, RFC 9276 recommends it only for very large and sparsely signed delegation zones"
+			res.Information = append(res.Information, msg)
+			break
+		}
+	}
+	res.Information = append(res.Information, fmt.Sprintf("PoC: dig -t NSEC3PARAM +norecurse %v @%v", apex, ip))
+}
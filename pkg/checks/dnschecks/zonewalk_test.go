@@ -0,0 +1,84 @@
+package dnschecks
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// nsecServer answers the NSEC queries of the walk with the record returned
+// by next for the queried name
+func nsecServer(t *testing.T, next func(name string) string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+		name := req.Question[0].Name
+		m.Answer = append(m.Answer, &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: next(name),
+			TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+		})
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestZoneWalk(t *testing.T) {
+	chain := map[string]string{
+		"example.com.":        "_dmarc.example.com.",
+		"_dmarc.example.com.": "mail.example.com.",
+		"mail.example.com.":   "www.example.com.",
+		"www.example.com.":    "example.com.",
+	}
+
+	tests := []struct {
+		name     string
+		next     func(string) string
+		names    []string
+		complete bool
+		err      error
+	}{
+		{
+			name:     "plain NSEC chain",
+			next:     func(name string) string { return chain[strings.ToLower(name)] },
+			names:    []string{"example.com", "_dmarc.example.com", "mail.example.com", "www.example.com"},
+			complete: true,
+		},
+		{
+			name:  "online signer",
+			next:  func(name string) string { return "\\000." + name },
+			names: []string{"example.com"},
+			err:   errSynthesizedNSEC,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ZoneWalkCheck{}
+			if err := c.Init(new(dns.Client)); err != nil {
+				t.Fatal(err)
+			}
+			names, complete, err := c.walk("example.com.", nsecServer(t, tt.next))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if complete != tt.complete {
+				t.Errorf("complete = %v, want %v", complete, tt.complete)
+			}
+			if strings.Join(names, " ") != strings.Join(tt.names, " ") {
+				t.Errorf("names = %v, want %v", names, tt.names)
+			}
+		})
+	}
+}
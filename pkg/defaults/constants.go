@@ -1,12 +1,96 @@
 package defaults
 
+import "time"
+
 const (
 	// DefaultNameserver is used when no other nameserver has been specified
 	// it is equivalent to dns.google.com
 	DefaultNameserver = "8.8.8.8"
-	// DNSAmplificationThreshold is an arbitrary number that the programmer
-	// considered to be enough for "response considerably larger than request"
-	DNSAmplificationThreshold = 5
+	// DNSAmplificationThreshold is the ratio between the size of the answer
+	// and the size of the request that the programmer considered to be
+	// enough for "response considerably larger than request"
+	DNSAmplificationThreshold = 10
+	// DNSAmplificationBufsize is the EDNS buffer size advertised when
+	// measuring the amplification, as an attacker would do
+	DNSAmplificationBufsize = 4096
 	// DefaultWhoisServer - default whois server
 	DefaultWhoisServer = "whois.cymru.com"
+	// DefaultIRRServer is the IRR whois server queried for route objects,
+	// RADb mirrors most of the other registries
+	DefaultIRRServer = "whois.radb.net"
+	// SignatureExpiryWindow is how long before their expiration the RRSIG
+	// records are reported, re-signing usually happens well before
+	SignatureExpiryWindow = 7 * 24 * time.Hour
+	// SPFProbeAddress is the client address used to evaluate SPF policies as
+	// a spoofed sender would, it belongs to TEST-NET-2 (RFC 5737) so no
+	// policy should authorize it
+	SPFProbeAddress = "198.51.100.1"
+	// SPFBroadIPv4Prefix and SPFBroadIPv6Prefix are the prefix lengths
+	// below which a network authorized by SPF is reported as too broad
+	SPFBroadIPv4Prefix = 16
+	SPFBroadIPv6Prefix = 32
+	// ZoneWalkLimit is the default number of names enumerated by walking
+	// the NSEC chain of a zone
+	ZoneWalkLimit = 100
+	// MinRSAKeySize is the smallest RSA DNSKEY considered strong enough
+	MinRSAKeySize = 2048
+	// MinDKIMKeySize is the smallest RSA DKIM key verifiers accept (RFC 8301)
+	// and RecommendedDKIMKeySize the size signers should use
+	MinDKIMKeySize         = 1024
+	RecommendedDKIMKeySize = 2048
+	// DKIMWorkers is the number of DKIM selectors probed concurrently on
+	// every nameserver
+	DKIMWorkers = 10
 )
+
+// RootServers are the IPv4 addresses of the root nameservers (a to m), taken
+// from the root hints file https://www.internic.net/domain/named.root
+var RootServers = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+// RootTrustAnchors are the DS records of the root KSKs (KSK-2017 and
+// KSK-2024), taken from https://data.iana.org/root-anchors/root-anchors.xml
+var RootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// SharedSPFIncludes are SPF includes covering the whole sending platform of
+// a provider, any other customer of the provider passes SPF for the domains
+// including them
+var SharedSPFIncludes = map[string]string{
+	"_spf.google.com":            "Google Workspace",
+	"spf.protection.outlook.com": "Microsoft 365",
+	"amazonses.com":              "Amazon SES",
+	"sendgrid.net":               "SendGrid",
+	"mailgun.org":                "Mailgun",
+	"servers.mcsv.net":           "Mailchimp",
+	"spf.mandrillapp.com":        "Mandrill",
+	"_spf.salesforce.com":        "Salesforce",
+	"spf.sendinblue.com":         "Brevo",
+	"mail.zendesk.com":           "Zendesk",
+	"spf.mtasv.net":              "Postmark",
+	"zoho.com":                   "Zoho",
+	"spf.messagingengine.com":    "Fastmail",
+	"spf.constantcontact.com":    "Constant Contact",
+}
+
+// RecursionProbes are names asked to authoritative nameservers to find out if
+// they recurse, the first one that is not in the assessed zone is used
+var RecursionProbes = []string{
+	"www.google.com",
+	"www.wikipedia.org",
+}
@@ -2,12 +2,31 @@ package output
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/projectdiscovery/gologger"
 )
 
+// DomainOutput groups the results of every check run against a domain
+type DomainOutput struct {
+	Domain string         `json:"domain"`
+	Zone   string         `json:"zone,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	Checks []*CheckOutput `json:"checks"`
+}
+
+// Severities of the checks, a check without severity is considered of
+// medium severity
+const (
+	SeverityHigh = "high"
+)
+
 type CheckOutput struct {
 	Name        string              `json:"name"`
+	Severity    string              `json:"severity,omitempty"`
 	Domain      string              `json:"domain"`
 	Nameservers []string            `json:"nameservers"`
 	Description []string            `json:"description"`
@@ -16,17 +35,33 @@ type CheckOutput struct {
 
 type SingleCheckResult struct {
 	Nameserver  string   `json:"nameserver"`
+	Address     string   `json:"address,omitempty"`
 	Zone        string   `json:"zone"`
 	Vulnerable  bool     `json:"is_vulnerable"`
 	Information []string `json:"info"`
+	// Evidence holds the data collected to prove a finding, such as the
+	// names enumerated from the zone
+	Evidence []string `json:"evidence,omitempty"`
 }
 
-func (o *CheckOutput) PrintSilent() {
+// Failed tells if at least one nameserver is vulnerable
+func (o *CheckOutput) Failed() bool {
 	for _, res := range o.Results {
 		if res.Vulnerable {
-			gologger.Error().Label("FAILED").Msgf("%v is positive to check: %v\n", o.Domain, o.Name)
+			return true
+		}
+	}
+	return false
+}
+
+func (o *CheckOutput) PrintSilent() {
+	if o.Failed() {
+		if o.Severity != "" {
+			gologger.Error().Label("FAILED").Msgf("%v is positive to check: %v (%v severity)\n", o.Domain, o.Name, o.Severity)
 			return
 		}
+		gologger.Error().Label("FAILED").Msgf("%v is positive to check: %v\n", o.Domain, o.Name)
+		return
 	}
 	gologger.Debug().Label("PASSED").Msgf("%v is negative to check: %v\n", o.Domain, o.Name)
 }
@@ -34,6 +69,9 @@ func (o *CheckOutput) PrintSilent() {
 func (o *CheckOutput) PrintVerbose() {
 	gologger.Info().Label(o.Name).Msgf("Name: %v", o.Name)
 	gologger.Info().Label(o.Name).Msgf("Domain: %v", o.Domain)
+	if o.Severity != "" {
+		gologger.Info().Label(o.Name).Msgf("Severity: %v", o.Severity)
+	}
 	gologger.Info().Label(o.Name).Msgf("")
 	gologger.Info().Label(o.Name).Msgf("Description:\n")
 	for _, d := range o.Description {
@@ -41,15 +79,49 @@ func (o *CheckOutput) PrintVerbose() {
 	}
 	gologger.Info().Label(o.Name).Msgf("")
 	for _, r := range o.Results {
+		server := r.Nameserver
+		if r.Address != "" {
+			server = fmt.Sprintf("%v (%v)", r.Nameserver, r.Address)
+		}
 		if r.Vulnerable {
-			gologger.Warning().Label(o.Name).Msgf("%v failed this check on %v", r.Nameserver, o.Domain)
+			gologger.Warning().Label(o.Name).Msgf("%v failed this check on %v", server, o.Domain)
 			for _, i := range r.Information {
 				gologger.Warning().Label(o.Name).Msgf("%v\n", i)
 			}
+			if len(r.Evidence) > 0 {
+				gologger.Warning().Label(o.Name).Msgf("%d items of evidence saved in the JSON output\n", len(r.Evidence))
+			}
 		} else {
-			gologger.Debug().Label(o.Name).Msgf("%v passed this check on %v", r.Nameserver, o.Domain)
+			gologger.Debug().Label(o.Name).Msgf("%v passed this check on %v", server, o.Domain)
 		}
 	}
 
 	fmt.Println("")
 }
+
+// PrintSummary prints a table with the checks failed by every domain
+func PrintSummary(domains []*DomainOutput) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tSTATUS\tFAILED CHECKS")
+	for _, d := range domains {
+		if d.Error != "" {
+			fmt.Fprintf(w, "%v\tERROR\t%v\n", d.Domain, d.Error)
+			continue
+		}
+
+		var failed []string
+		for _, c := range d.Checks {
+			if c.Failed() {
+				failed = append(failed, c.Name)
+			}
+		}
+		sort.Strings(failed)
+		if len(failed) == 0 {
+			fmt.Fprintf(w, "%v\tPASSED\t-\n", d.Domain)
+		} else {
+			fmt.Fprintf(w, "%v\tFAILED\t%v\n", d.Domain, strings.Join(failed, ", "))
+		}
+	}
+	w.Flush()
+	fmt.Println("")
+}
@@ -10,13 +10,19 @@ import (
 )
 
 type ASN struct {
-	ID   string
-	IP   net.IP
-	Name string
+	ID       string
+	IP       net.IP
+	Prefix   *net.IPNet
+	Country  string
+	Registry string
+	Name     string
 }
 
+// NewASN asks the whois server for the origin AS of the given address, the
+// verbose flag makes it return the announced BGP prefix as well:
+// AS | IP | BGP Prefix | CC | Registry | Allocated | AS Name
 func NewASN(nameserver net.IP) (*ASN, error) {
-	r, err := whois.Whois(nameserver.String(), defaults.DefaultWhoisServer)
+	r, err := whois.Whois(fmt.Sprintf("-v %v", nameserver.String()), defaults.DefaultWhoisServer)
 	if err != nil {
 		return nil, err
 	}
@@ -27,12 +33,25 @@ func NewASN(nameserver net.IP) (*ASN, error) {
 	}
 
 	fields := strings.Split(strings.TrimSpace(lines[1]), "|")
-	if len(fields) != 3 {
+	if len(fields) != 7 {
 		return nil, fmt.Errorf("not enough fields")
 	}
-	return &ASN{
-		ID:   fields[0],
-		IP:   net.ParseIP(strings.ReplaceAll(fields[1], " ", "")),
-		Name: fields[2],
-	}, nil
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	if fields[0] == "NA" {
+		return nil, fmt.Errorf("%v is not announced by any AS", nameserver)
+	}
+
+	asn := &ASN{
+		ID:       fields[0],
+		IP:       net.ParseIP(fields[1]),
+		Country:  fields[3],
+		Registry: fields[4],
+		Name:     fields[6],
+	}
+	if _, prefix, err := net.ParseCIDR(fields[2]); err == nil {
+		asn.Prefix = prefix
+	}
+	return asn, nil
 }
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/miekg/dns"
+)
+
+// maxReferrals bounds the number of referrals followed from the root
+const maxReferrals = 16
+
+// Delegation is an NS RRset along with the glue records that came with it
+// and the address of the server that provided them
+type Delegation struct {
+	Server string
+	NS     []*dns.NS
+	Glue   []dns.RR
+}
+
+// Names returns the nameserver names of the delegation, without the
+// trailing dot
+func (d *Delegation) Names() []string {
+	var names []string
+	for _, ns := range d.NS {
+		names = append(names, strings.Trim(ns.Ns, "."))
+	}
+	return names
+}
+
+// GlueFor returns the addresses the delegation carries for the nameserver
+func (d *Delegation) GlueFor(fqdn string) []net.IP {
+	var ips []net.IP
+	for _, rr := range d.Glue {
+		if !strings.EqualFold(rr.Header().Name, dns.Fqdn(fqdn)) {
+			continue
+		}
+		switch t := rr.(type) {
+		case *dns.A:
+			ips = append(ips, t.A)
+		case *dns.AAAA:
+			ips = append(ips, t.AAAA)
+		}
+	}
+	return ips
+}
+
+// FindDelegation walks the DNS tree from the root, following referrals, until
+// the zone containing domain is found. It returns the zone name, the NS set
+// published by the parent zone (from the last referral) and the NS set
+// answered authoritatively by the nameservers of the zone itself.
+// Addresses of nameservers without glue are looked up with the resolver
+func FindDelegation(domain string, resolver *Resolver) (string, *Delegation, *Delegation, error) {
+	var parent *Delegation
+	servers := defaults.RootServers
+	zone := "."
+	name := dns.Fqdn(domain)
+
+	for i := 0; i < maxReferrals; i++ {
+		r, server, err := queryIterative(MakeNonRecursiveQuery, servers, name, dns.TypeNS)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if r.Rcode == dns.RcodeNameError {
+			return "", nil, nil, fmt.Errorf("%v does not exist (NXDOMAIN from %v)", domain, server)
+		}
+
+		// A referral carries, in the authority section, the NS set of a
+		// zone closer to the domain than the one being asked
+		referral := nsRecords(r.Ns, "")
+		if !r.Authoritative && len(referral) > 0 {
+			cut := referral[0].Hdr.Name
+			if !dns.IsSubDomain(cut, name) || dns.CountLabel(cut) <= dns.CountLabel(zone) {
+				return "", nil, nil, fmt.Errorf("bad referral for %v from %v: %v", domain, server, cut)
+			}
+			zone = cut
+			parent = &Delegation{Server: server, NS: nsRecords(r.Ns, cut), Glue: glueRecords(r.Extra)}
+			if servers, err = delegationAddresses(parent, resolver); err != nil {
+				return "", nil, nil, err
+			}
+			continue
+		}
+
+		if !r.Authoritative {
+			return "", nil, nil, fmt.Errorf("%v is not authoritative for %v", server, domain)
+		}
+
+		// The answer comes from the zone containing the domain: either the
+		// domain is the apex, or the SOA in the authority section tells
+		// which zone it belongs to
+		child := nsRecords(r.Answer, name)
+		if len(child) > 0 {
+			zone = name
+		} else {
+			for _, rr := range r.Ns {
+				if soa, ok := rr.(*dns.SOA); ok {
+					zone = soa.Hdr.Name
+				}
+			}
+			if r, err = MakeNonRecursiveQuery(new(dns.Client), zone, server, dns.TypeNS); err != nil {
+				return "", nil, nil, err
+			}
+			child = nsRecords(r.Answer, zone)
+		}
+		if len(child) == 0 {
+			return "", nil, nil, fmt.Errorf("no NS records found for zone %v on %v", zone, server)
+		}
+		if parent != nil && !strings.EqualFold(parent.NS[0].Hdr.Name, zone) {
+			// the same servers are authoritative for the parent zone, so
+			// no referral toward the zone has been seen
+			parent = nil
+		}
+		return strings.TrimSuffix(zone, "."), parent, &Delegation{Server: server, NS: child, Glue: glueRecords(r.Extra)}, nil
+	}
+	return "", nil, nil, fmt.Errorf("too many referrals while resolving %v", domain)
+}
+
+// queryFunc is the signature shared by the functions sending a single query
+type queryFunc func(c *dns.Client, query, nameserver string, qType uint16) (*dns.Msg, error)
+
+// queryIterative sends the query to the servers in order, until one of them
+// gives an answer that is not a failure
+func queryIterative(query queryFunc, servers []string, name string, qType uint16) (*dns.Msg, string, error) {
+	var lastErr error
+	for _, s := range servers {
+		server := net.JoinHostPort(s, "53")
+		r, err := query(new(dns.Client), name, server, qType)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%v answered %v for %v", server, dns.RcodeToString[r.Rcode], name)
+			continue
+		}
+		return r, server, nil
+	}
+	return nil, "", fmt.Errorf("no server answered the query for %v: %v", name, lastErr)
+}
+
+// delegationAddresses returns the addresses of the delegated nameservers,
+// taken from the glue when available
+func delegationAddresses(d *Delegation, resolver *Resolver) ([]string, error) {
+	var addrs []string
+	for _, fqdn := range d.Names() {
+		for _, ip := range d.GlueFor(fqdn) {
+			if ip.To4() != nil {
+				addrs = append(addrs, ip.String())
+			}
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	for _, fqdn := range d.Names() {
+		ips, _ := resolver.LookupIPs(fqdn)
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				addrs = append(addrs, ip.String())
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address found for nameservers %v", d.Names())
+	}
+	return addrs, nil
+}
+
+// nsRecords returns the NS records in rrs, only the ones owned by owner if it
+// is not empty
+func nsRecords(rrs []dns.RR, owner string) []*dns.NS {
+	var ns []*dns.NS
+	for _, rr := range rrs {
+		if t, ok := rr.(*dns.NS); ok {
+			if owner == "" || strings.EqualFold(t.Hdr.Name, owner) {
+				ns = append(ns, t)
+			}
+		}
+	}
+	return ns
+}
+
+func glueRecords(rrs []dns.RR) []dns.RR {
+	var glue []dns.RR
+	for _, rr := range rrs {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			glue = append(glue, rr)
+		}
+	}
+	return glue
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// DKIM key types, ed25519 is defined by RFC 8463
+const (
+	DKIMKeyRSA     = "rsa"
+	DKIMKeyEd25519 = "ed25519"
+)
+
+// DKIMKey is a parsed DKIM key record (RFC 6376 section 3.6.1), the tags not
+// published hold their default value and Tags tells which ones were published
+type DKIMKey struct {
+	Raw      string
+	KeyType  string
+	Hashes   []string
+	Services []string
+	Flags    []string
+	Notes    string
+	// PublicKey is the decoded key, nil when the key has been revoked
+	PublicKey crypto.PublicKey
+	Tags      map[string]string
+}
+
+// Revoked tells if the key has been revoked publishing an empty p tag
+func (k *DKIMKey) Revoked() bool {
+	return k.PublicKey == nil
+}
+
+// Testing tells if the domain is testing DKIM (t=y), verifiers must not
+// treat the messages differently from unsigned ones
+func (k *DKIMKey) Testing() bool {
+	return k.hasFlag("y")
+}
+
+func (k *DKIMKey) hasFlag(flag string) bool {
+	for _, f := range k.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Bits returns the size of the modulus of an RSA key, 0 for other keys
+func (k *DKIMKey) Bits() int {
+	if pub, ok := k.PublicKey.(*rsa.PublicKey); ok {
+		return pub.N.BitLen()
+	}
+	return 0
+}
+
+// ParseDKIMKey parses a DKIM key record and decodes its public key, any
+// syntax error or invalid value of a known tag is returned as an error
+func ParseDKIMKey(record string) (*DKIMKey, error) {
+	k := &DKIMKey{
+		Raw:      record,
+		KeyType:  DKIMKeyRSA,
+		Services: []string{"*"},
+		Tags:     make(map[string]string),
+	}
+
+	for i, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		name, value := strings.TrimSpace(part[:eq]), strings.TrimSpace(part[eq+1:])
+		if !tagName.MatchString(name) {
+			return nil, fmt.Errorf("malformed tag name %q", name)
+		}
+		if _, ok := k.Tags[name]; ok {
+			return nil, fmt.Errorf("tag %v appears more than once", name)
+		}
+		k.Tags[name] = value
+
+		switch name {
+		case "v":
+			if i != 0 || value != "DKIM1" {
+				return nil, fmt.Errorf("the v tag must be the first one and be DKIM1")
+			}
+		case "k":
+			k.KeyType = strings.ToLower(value)
+		case "h":
+			k.Hashes = splitDKIMList(value)
+		case "s":
+			k.Services = splitDKIMList(value)
+		case "t":
+			k.Flags = splitDKIMList(value)
+		case "n":
+			k.Notes = value
+		}
+	}
+
+	p, ok := k.Tags["p"]
+	if !ok {
+		return nil, fmt.Errorf("missing p tag")
+	}
+	// the key may be folded with whitespace
+	p = strings.Join(strings.Fields(p), "")
+	if p == "" {
+		return k, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p tag: %v", err)
+	}
+	if k.PublicKey, err = decodeDKIMKey(k.KeyType, der); err != nil {
+		return nil, fmt.Errorf("invalid p tag: %v", err)
+	}
+	return k, nil
+}
+
+// decodeDKIMKey decodes the public key of the given type. RSA keys are
+// SubjectPublicKeyInfo structures, some signers publish the bare PKCS#1 key
+func decodeDKIMKey(keyType string, der []byte) (crypto.PublicKey, error) {
+	switch keyType {
+	case DKIMKeyRSA:
+		if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+			if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+				return rsaPub, nil
+			}
+			return nil, fmt.Errorf("not an RSA key")
+		}
+		pub, err := x509.ParsePKCS1PublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed RSA key")
+		}
+		return pub, nil
+	case DKIMKeyEd25519:
+		if len(der) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 key of %d bytes instead of %d", len(der), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(der), nil
+	}
+	return nil, fmt.Errorf("unknown key type %q", keyType)
+}
+
+func splitDKIMList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ":") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func rsaKey(t *testing.T, bits int, pkcs1 bool) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkcs1 {
+		return base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	rsa1024 := rsaKey(t, 1024, false)
+	rsa2048 := rsaKey(t, 2048, false)
+	pkcs1 := rsaKey(t, 1024, true)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := base64.StdEncoding.EncodeToString(pub)
+
+	tests := []struct {
+		name     string
+		record   string
+		keyType  string
+		bits     int
+		revoked  bool
+		testing  bool
+		hashes   string
+		services string
+		err      bool
+	}{
+		{name: "rsa 1024", record: "v=DKIM1; k=rsa; p=" + rsa1024, keyType: DKIMKeyRSA, bits: 1024, services: "*"},
+		{name: "rsa 2048", record: "v=DKIM1; p=" + rsa2048, keyType: DKIMKeyRSA, bits: 2048, services: "*"},
+		{name: "pkcs1", record: "p=" + pkcs1, keyType: DKIMKeyRSA, bits: 1024, services: "*"},
+		{
+			name:    "folded key",
+			record:  "v=DKIM1; p=" + rsa2048[:100] + " " + rsa2048[100:200] + "\t" + rsa2048[200:],
+			keyType: DKIMKeyRSA, bits: 2048, services: "*",
+		},
+		{name: "ed25519", record: "v=DKIM1; k=ed25519; p=" + ed, keyType: DKIMKeyEd25519, services: "*"},
+		{name: "revoked", record: "v=DKIM1; p=", keyType: DKIMKeyRSA, revoked: true, services: "*"},
+		{
+			name:    "tags",
+			record:  "v=DKIM1; h=sha1:SHA256; s=email; t=y:s; n=rotated yearly; p=" + rsa1024,
+			keyType: DKIMKeyRSA, bits: 1024, testing: true, hashes: "sha1 sha256", services: "email",
+		},
+		{name: "strict only", record: "v=DKIM1; t=s; p=" + rsa1024, keyType: DKIMKeyRSA, bits: 1024, services: "*"},
+		{name: "unknown tag", record: "v=DKIM1; x=1; p=" + rsa1024, keyType: DKIMKeyRSA, bits: 1024, services: "*"},
+		{name: "missing p", record: "v=DKIM1; k=rsa", err: true},
+		{name: "v not first", record: "k=rsa; v=DKIM1; p=" + rsa1024, err: true},
+		{name: "wrong version", record: "v=DKIM2; p=" + rsa1024, err: true},
+		{name: "duplicate tag", record: "v=DKIM1; p=; p=" + rsa1024, err: true},
+		{name: "malformed tag", record: "v=DKIM1; p", err: true},
+		{name: "bad tag name", record: "v=DKIM1; 1x=y; p=", err: true},
+		{name: "invalid base64", record: "v=DKIM1; p=not*base64", err: true},
+		{name: "garbage key", record: "v=DKIM1; p=" + base64.StdEncoding.EncodeToString([]byte("garbage")), err: true},
+		{name: "short ed25519", record: "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub[:16]), err: true},
+		{name: "ed25519 as rsa", record: "v=DKIM1; k=rsa; p=" + ed, err: true},
+		{name: "unknown key type", record: "v=DKIM1; k=dsa; p=" + rsa1024, err: true},
+	}
+
+	for _, tt := range tests {
+		k, err := ParseDKIMKey(tt.record)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if k.KeyType != tt.keyType || k.Bits() != tt.bits {
+			t.Errorf("%v: %v key of %d bits, want %v of %d", tt.name, k.KeyType, k.Bits(), tt.keyType, tt.bits)
+		}
+		if k.Revoked() != tt.revoked || k.Testing() != tt.testing {
+			t.Errorf("%v: revoked %v, testing %v", tt.name, k.Revoked(), k.Testing())
+		}
+		if got := strings.Join(k.Hashes, " "); got != tt.hashes {
+			t.Errorf("%v: hashes %q, want %q", tt.name, got, tt.hashes)
+		}
+		if got := strings.Join(k.Services, " "); got != tt.services {
+			t.Errorf("%v: services %q, want %q", tt.name, got, tt.services)
+		}
+	}
+}
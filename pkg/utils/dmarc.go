@@ -0,0 +1,383 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DMARC policies as defined in RFC 7489 section 6.3, in order of strength
+const (
+	DMARCNone       = "none"
+	DMARCQuarantine = "quarantine"
+	DMARCReject     = "reject"
+)
+
+// dmarcPolicyStrength ranks the policies to compare them
+var dmarcPolicyStrength = map[string]int{
+	DMARCNone:       0,
+	DMARCQuarantine: 1,
+	DMARCReject:     2,
+}
+
+// tagName matches a tag name of the tag-value lists of DKIM and DMARC records
+// (RFC 6376 section 3.2, RFC 7489 section 6.4)
+var tagName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// dmarcSize matches the maximum report size that may follow a report URI
+var dmarcSize = regexp.MustCompile(`^[0-9]+[kmgtKMGT]?$`)
+
+// DMARCURI is a destination of the reports, MaxSize is empty when the URI
+// carries no size limit
+type DMARCURI struct {
+	URI     *url.URL
+	MaxSize string
+}
+
+func (u DMARCURI) String() string {
+	if u.MaxSize != "" {
+		return u.URI.String() + "!" + u.MaxSize
+	}
+	return u.URI.String()
+}
+
+// Domain returns the domain receiving the reports of a mailto URI, empty
+// for the other schemes
+func (u DMARCURI) Domain() string {
+	if !strings.EqualFold(u.URI.Scheme, "mailto") {
+		return ""
+	}
+	addr := u.URI.Opaque
+	if i := strings.IndexByte(addr, '?'); i >= 0 {
+		addr = addr[:i]
+	}
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return strings.ToLower(strings.TrimSuffix(addr[i+1:], "."))
+	}
+	return ""
+}
+
+// dmarcMaxLabels is the number of labels above which the DMARCbis tree walk
+// skips to the name made of the last dmarcWalkLabels ones
+const (
+	dmarcMaxLabels  = 8
+	dmarcWalkLabels = 7
+)
+
+// DMARCRecord is a parsed DMARC record, the tags not published hold their
+// default value and Tags tells which ones were published. NonexistentPolicy
+// and PSD are the np and psd tags introduced by DMARCbis
+type DMARCRecord struct {
+	Raw               string
+	Policy            string
+	SubdomainPolicy   string
+	NonexistentPolicy string
+	PSD               string
+	Percent           int
+	ADKIM             string
+	ASPF              string
+	AggregateURIs     []DMARCURI
+	FailureURIs       []DMARCURI
+	FailureOptions    []string
+	ReportFormats     []string
+	Interval          uint32
+	Tags              map[string]string
+}
+
+// DMARCStronger tells if policy a is stricter than policy b
+func DMARCStronger(a, b string) bool {
+	return dmarcPolicyStrength[a] > dmarcPolicyStrength[b]
+}
+
+// ParseDMARC parses a DMARC record, any syntax error or invalid value of a
+// known tag is returned as an error. Unknown tags are ignored as required by
+// RFC 7489 section 6.3
+func ParseDMARC(record string) (*DMARCRecord, error) {
+	d := &DMARCRecord{
+		Raw:            record,
+		Percent:        100,
+		ADKIM:          "r",
+		ASPF:           "r",
+		PSD:            "u",
+		FailureOptions: []string{"0"},
+		ReportFormats:  []string{"afrf"},
+		Interval:       86400,
+		Tags:           make(map[string]string),
+	}
+
+	for i, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			// a trailing separator is allowed
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		name, value := strings.TrimSpace(part[:eq]), strings.TrimSpace(part[eq+1:])
+		if !tagName.MatchString(name) {
+			return nil, fmt.Errorf("malformed tag name %q", name)
+		}
+		name = strings.ToLower(name)
+		if i == 0 && (name != "v" || value != "DMARC1") {
+			return nil, fmt.Errorf("the record must start with v=DMARC1")
+		}
+		if _, ok := d.Tags[name]; ok {
+			return nil, fmt.Errorf("tag %v appears more than once", name)
+		}
+		d.Tags[name] = value
+		if err := d.parseTag(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %v tag: %v", name, err)
+		}
+	}
+
+	// without p the record is still valid when it asks for aggregate
+	// reports, and it is treated as p=none (RFC 7489 section 6.6.3)
+	if _, ok := d.Tags["p"]; !ok {
+		if len(d.AggregateURIs) == 0 {
+			return nil, fmt.Errorf("missing p tag")
+		}
+		d.Policy = DMARCNone
+	}
+	if _, ok := d.Tags["sp"]; !ok {
+		d.SubdomainPolicy = d.Policy
+	}
+	if _, ok := d.Tags["np"]; !ok {
+		d.NonexistentPolicy = d.SubdomainPolicy
+	}
+	return d, nil
+}
+
+func (d *DMARCRecord) parseTag(name, value string) error {
+	var err error
+	switch name {
+	case "p":
+		d.Policy, err = parseDMARCPolicy(value)
+	case "sp":
+		d.SubdomainPolicy, err = parseDMARCPolicy(value)
+	case "np":
+		d.NonexistentPolicy, err = parseDMARCPolicy(value)
+	case "psd":
+		d.PSD = strings.ToLower(value)
+		if d.PSD != "y" && d.PSD != "n" && d.PSD != "u" {
+			return fmt.Errorf("unknown value %q", value)
+		}
+	case "pct":
+		d.Percent, err = strconv.Atoi(value)
+		if err == nil && (d.Percent < 0 || d.Percent > 100) {
+			err = fmt.Errorf("%d is not between 0 and 100", d.Percent)
+		}
+	case "adkim", "aspf":
+		mode := strings.ToLower(value)
+		if mode != "r" && mode != "s" {
+			return fmt.Errorf("unknown alignment mode %q", value)
+		}
+		if name == "adkim" {
+			d.ADKIM = mode
+		} else {
+			d.ASPF = mode
+		}
+	case "rua":
+		d.AggregateURIs, err = parseDMARCURIs(value)
+	case "ruf":
+		d.FailureURIs, err = parseDMARCURIs(value)
+	case "fo":
+		d.FailureOptions = nil
+		for _, o := range strings.Split(value, ":") {
+			o = strings.TrimSpace(o)
+			if o != "0" && o != "1" && o != "d" && o != "s" {
+				return fmt.Errorf("unknown failure reporting option %q", o)
+			}
+			d.FailureOptions = append(d.FailureOptions, o)
+		}
+	case "rf":
+		d.ReportFormats = nil
+		for _, f := range strings.Split(value, ":") {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if f != "afrf" {
+				return fmt.Errorf("unknown report format %q", f)
+			}
+			d.ReportFormats = append(d.ReportFormats, f)
+		}
+	case "ri":
+		var ri uint64
+		ri, err = strconv.ParseUint(value, 10, 32)
+		d.Interval = uint32(ri)
+	}
+	return err
+}
+
+func parseDMARCPolicy(value string) (string, error) {
+	p := strings.ToLower(value)
+	if _, ok := dmarcPolicyStrength[p]; !ok {
+		return "", fmt.Errorf("unknown policy %q", value)
+	}
+	return p, nil
+}
+
+// parseDMARCURIs parses a comma separated list of report URIs with their
+// optional size limit (RFC 7489 section 6.2)
+func parseDMARCURIs(value string) ([]DMARCURI, error) {
+	var uris []DMARCURI
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		var u DMARCURI
+		if i := strings.LastIndexByte(s, '!'); i >= 0 {
+			if !dmarcSize.MatchString(s[i+1:]) {
+				return nil, fmt.Errorf("invalid size limit in %q", s)
+			}
+			s, u.MaxSize = s[:i], s[i+1:]
+		}
+		parsed, err := url.Parse(s)
+		if err != nil || parsed.Scheme == "" {
+			return nil, fmt.Errorf("invalid URI %q", s)
+		}
+		u.URI = parsed
+		if strings.EqualFold(parsed.Scheme, "mailto") && u.Domain() == "" {
+			return nil, fmt.Errorf("invalid mailto URI %q", s)
+		}
+		uris = append(uris, u)
+	}
+	return uris, nil
+}
+
+// SelectDMARC returns the DMARC record among the TXT records of a _dmarc
+// name, the strings of a TXT record are joined. More than one DMARC record
+// means there is no policy to apply (RFC 7489 section 6.6.3)
+func SelectDMARC(rrs []dns.RR) (string, error) {
+	var records []string
+	for _, rr := range rrs {
+		t, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		txt := strings.Join(t.Txt, "")
+		if isDMARC(txt) {
+			records = append(records, txt)
+		}
+	}
+	if len(records) > 1 {
+		return "", fmt.Errorf("%d DMARC records published, receivers apply no policy", len(records))
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+	return records[0], nil
+}
+
+// isDMARC tells if the TXT record starts with the DMARC version tag, the
+// others are discarded by receivers
+func isDMARC(txt string) bool {
+	v := strings.SplitN(txt, ";", 2)[0]
+	eq := strings.IndexByte(v, '=')
+	return eq >= 0 && strings.TrimSpace(v[:eq]) == "v" && strings.TrimSpace(v[eq+1:]) == "DMARC1"
+}
+
+// DMARCDiscovery is the outcome of the DMARCbis tree walk for a domain
+type DMARCDiscovery struct {
+	Domain string
+	// Source is the name whose record governs Domain, empty when no
+	// record has been found
+	Source string
+	Record *DMARCRecord
+	// OrganizationalDomain is the organizational domain determined from
+	// the psd tags of the records found along the walk
+	OrganizationalDomain string
+	Queried              []string
+	// Skipped are the names whose record could not be used
+	Skipped []string
+}
+
+// Policy returns the policy applied to mail from Domain and the tag it comes
+// from, exists tells whether Domain is an existing name
+func (d *DMARCDiscovery) Policy(exists bool) (string, string) {
+	switch {
+	case d.Record == nil:
+		return "", ""
+	case strings.EqualFold(d.Source, d.Domain):
+		return d.Record.Policy, "p"
+	case !exists:
+		return d.Record.NonexistentPolicy, "np"
+	default:
+		return d.Record.SubdomainPolicy, "sp"
+	}
+}
+
+// DMARCTreeWalk looks for the DMARC record governing domain, walking from the
+// domain towards the TLD as defined by DMARCbis (draft-ietf-dmarc-dmarcbis
+// section 4.10). The first valid record found governs the domain, the walk
+// goes on up to the TLD to determine the organizational domain
+func DMARCTreeWalk(resolver *Resolver, domain string) (*DMARCDiscovery, error) {
+	d := &DMARCDiscovery{Domain: strings.TrimSuffix(strings.ToLower(domain), ".")}
+	labels := dns.SplitDomainName(d.Domain)
+
+	var names []string
+	names = append(names, d.Domain)
+	first := 1
+	if len(labels) > dmarcMaxLabels {
+		first = len(labels) - dmarcWalkLabels
+	}
+	for i := first; i < len(labels); i++ {
+		names = append(names, strings.Join(labels[i:], "."))
+	}
+
+	// records found along the walk, from the domain to the TLD
+	type found struct {
+		name   string
+		record *DMARCRecord
+	}
+	var records []found
+	for _, name := range names {
+		query := "_dmarc." + name
+		d.Queried = append(d.Queried, query)
+		r, err := resolver.Query(query, dns.TypeTXT)
+		if errors.Is(err, ErrNXDomain) {
+			continue
+		}
+		if err != nil {
+			return d, err
+		}
+		raw, err := SelectDMARC(r.Answer)
+		if err == nil && raw == "" {
+			continue
+		}
+		var record *DMARCRecord
+		if err == nil {
+			record, err = ParseDMARC(raw)
+		}
+		if err != nil {
+			d.Skipped = append(d.Skipped, fmt.Sprintf("%v: %v", query, err))
+			continue
+		}
+		records = append(records, found{name, record})
+	}
+	if len(records) == 0 {
+		return d, nil
+	}
+	d.Source, d.Record = records[0].name, records[0].record
+
+	// the organizational domain is the first name with psd=n, the name
+	// right below the first one with psd=y, or else the shortest name
+	// publishing a record (DMARCbis section 4.10.2)
+	d.OrganizationalDomain = records[len(records)-1].name
+	for _, f := range records {
+		if f.record.PSD == "n" {
+			d.OrganizationalDomain = f.name
+			break
+		}
+		if f.record.PSD == "y" {
+			d.OrganizationalDomain = f.name
+			if n := len(dns.SplitDomainName(f.name)); n < len(labels) {
+				d.OrganizationalDomain = strings.Join(labels[len(labels)-n-1:], ".")
+			}
+			break
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseDMARC(t *testing.T) {
+	tests := []struct {
+		record string
+		policy string // p, sp and np
+		pct    int
+		align  string // adkim and aspf
+		psd    string
+		rua    string
+		err    bool
+	}{
+		{record: "v=DMARC1; p=reject", policy: "reject reject reject", pct: 100, align: "r r", psd: "u"},
+		{record: "v=DMARC1; p=none; sp=quarantine;", policy: "none quarantine quarantine", pct: 100, align: "r r", psd: "u"},
+		{record: "v=DMARC1; p=reject; np=none", policy: "reject reject none", pct: 100, align: "r r", psd: "u"},
+		{record: "v=DMARC1; p=Quarantine; sp=none; np=reject; pct=50; adkim=s; aspf=S; psd=y", policy: "quarantine none reject", pct: 50, align: "s s", psd: "y"},
+		{
+			record: "v=DMARC1; rua=mailto:dmarc@example.com!10m, mailto:agg@example.net",
+			policy: "none none none", pct: 100, align: "r r", psd: "u",
+			rua: "mailto:dmarc@example.com!10m mailto:agg@example.net",
+		},
+		{record: "v=DMARC1; p=reject; unknown=tag", policy: "reject reject reject", pct: 100, align: "r r", psd: "u"},
+		{record: "v=DMARC1", err: true},
+		{record: "p=reject; v=DMARC1", err: true},
+		{record: "v=DMARC2; p=reject", err: true},
+		{record: "v=DMARC1; p=reject; p=none", err: true},
+		{record: "v=DMARC1; p=block", err: true},
+		{record: "v=DMARC1; p=reject; sp=maybe", err: true},
+		{record: "v=DMARC1; p=reject; np=maybe", err: true},
+		{record: "v=DMARC1; p=reject; pct=101", err: true},
+		{record: "v=DMARC1; p=reject; adkim=x", err: true},
+		{record: "v=DMARC1; p=reject; psd=x", err: true},
+		{record: "v=DMARC1; p=reject; fo=2", err: true},
+		{record: "v=DMARC1; p=reject; rf=iodef", err: true},
+		{record: "v=DMARC1; p=reject; ri=-1", err: true},
+		{record: "v=DMARC1; p=reject; rua=dmarc@example.com", err: true},
+		{record: "v=DMARC1; p=reject; rua=mailto:dmarc@example.com!10x", err: true},
+		{record: "v=DMARC1; p=reject; rua", err: true},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDMARC(tt.record)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseDMARC(%q): no error", tt.record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDMARC(%q): %v", tt.record, err)
+			continue
+		}
+		if got := strings.Join([]string{d.Policy, d.SubdomainPolicy, d.NonexistentPolicy}, " "); got != tt.policy {
+			t.Errorf("ParseDMARC(%q): policies = %q, want %q", tt.record, got, tt.policy)
+		}
+		if d.Percent != tt.pct || d.ADKIM+" "+d.ASPF != tt.align || d.PSD != tt.psd {
+			t.Errorf("ParseDMARC(%q): pct=%d adkim=%v aspf=%v psd=%v", tt.record, d.Percent, d.ADKIM, d.ASPF, d.PSD)
+		}
+		var rua []string
+		for _, u := range d.AggregateURIs {
+			rua = append(rua, u.String())
+		}
+		if got := strings.Join(rua, " "); got != tt.rua {
+			t.Errorf("ParseDMARC(%q): rua = %q, want %q", tt.record, got, tt.rua)
+		}
+	}
+}
+
+func TestSelectDMARC(t *testing.T) {
+	tests := []struct {
+		txt    []string
+		record string
+		err    bool
+	}{
+		{txt: []string{"v=spf1 -all", "v=DMARC1; p=reject"}, record: "v=DMARC1; p=reject"},
+		{txt: []string{"v=DMARC1 ; p=none"}, record: "v=DMARC1 ; p=none"},
+		{txt: []string{"v=DMARC10; p=none"}, record: ""},
+		{txt: nil, record: ""},
+		{txt: []string{"v=DMARC1; p=reject", "v=DMARC1; p=none"}, err: true},
+	}
+
+	for _, tt := range tests {
+		var rrs []dns.RR
+		for _, s := range tt.txt {
+			rrs = append(rrs, &dns.TXT{Hdr: dns.RR_Header{Name: "_dmarc.example.com.", Rrtype: dns.TypeTXT}, Txt: []string{s}})
+		}
+		record, err := SelectDMARC(rrs)
+		if tt.err {
+			if err == nil {
+				t.Errorf("SelectDMARC(%q): no error", tt.txt)
+			}
+			continue
+		}
+		if err != nil || record != tt.record {
+			t.Errorf("SelectDMARC(%q) = %q, %v, want %q", tt.txt, record, err, tt.record)
+		}
+	}
+}
+
+func TestDMARCTreeWalk(t *testing.T) {
+	r := fakeResolver(t, `
+_dmarc.com. 300 IN TXT "v=DMARC1; p=none; psd=y"
+_dmarc.example.com. 300 IN TXT "v=DMARC1; p=reject; sp=quarantine; np=none"
+_dmarc.own.example.com. 300 IN TXT "v=DMARC1; p=none"
+_dmarc.bad.example.com. 300 IN TXT "v=DMARC1; p=bogus"
+_dmarc.example.net. 300 IN TXT "v=DMARC1; p=quarantine"
+_dmarc.corp.example.net. 300 IN TXT "v=DMARC1; p=reject; psd=n"
+`)
+
+	tests := []struct {
+		domain  string
+		source  string
+		org     string
+		exists  string // policy applied to the domain when it exists
+		nx      string // policy applied to the domain when it does not
+		queried int
+		skipped int
+	}{
+		{domain: "example.com", source: "example.com", org: "example.com", exists: "p=reject", nx: "p=reject", queried: 2},
+		{domain: "a.b.example.com", source: "example.com", org: "example.com", exists: "sp=quarantine", nx: "np=none", queried: 4},
+		{domain: "own.example.com", source: "own.example.com", org: "example.com", exists: "p=none", nx: "p=none", queried: 3},
+		{domain: "x.bad.example.com", source: "example.com", org: "example.com", exists: "sp=quarantine", nx: "np=none", queried: 4, skipped: 1},
+		{domain: "host.corp.example.net", source: "corp.example.net", org: "corp.example.net", exists: "sp=reject", nx: "np=reject", queried: 4},
+		{domain: "host.example.net", source: "example.net", org: "example.net", exists: "sp=quarantine", nx: "np=quarantine", queried: 3},
+		{domain: "a.b.c.d.e.f.g.h.example.com", source: "example.com", org: "example.com", exists: "sp=quarantine", nx: "np=none", queried: 8},
+		{domain: "example.org", queried: 2},
+	}
+
+	for _, tt := range tests {
+		d, err := DMARCTreeWalk(r, tt.domain)
+		if err != nil {
+			t.Errorf("DMARCTreeWalk(%v): %v", tt.domain, err)
+			continue
+		}
+		if d.Source != tt.source || d.OrganizationalDomain != tt.org {
+			t.Errorf("DMARCTreeWalk(%v): source %q, organizational domain %q, want %q and %q", tt.domain, d.Source, d.OrganizationalDomain, tt.source, tt.org)
+		}
+		if len(d.Queried) != tt.queried || len(d.Skipped) != tt.skipped {
+			t.Errorf("DMARCTreeWalk(%v): queried %q, skipped %q", tt.domain, d.Queried, d.Skipped)
+		}
+		if tt.source == "" {
+			if d.Record != nil {
+				t.Errorf("DMARCTreeWalk(%v): unexpected record %v", tt.domain, d.Record.Raw)
+			}
+			continue
+		}
+		for exists, want := range map[bool]string{true: tt.exists, false: tt.nx} {
+			policy, tag := d.Policy(exists)
+			if got := tag + "=" + policy; got != want {
+				t.Errorf("DMARCTreeWalk(%v): Policy(%v) = %v, want %v", tt.domain, exists, got, want)
+			}
+		}
+	}
+}
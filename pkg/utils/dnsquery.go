@@ -2,7 +2,10 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"syscall"
 	"time"
 
 	"github.com/miekg/dns"
@@ -13,10 +16,7 @@ func MakeQuery(c *dns.Client, query, nameserver string, qType uint16) (*dns.Msg,
 	m.RecursionDesired = true
 	m.SetQuestion(query, qType)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	r, _, err := c.ExchangeContext(ctx, m, nameserver)
+	r, err := Exchange(c, m, nameserver)
 	if err != nil {
 		return nil, err
 	}
@@ -25,3 +25,96 @@ func MakeQuery(c *dns.Client, query, nameserver string, qType uint16) (*dns.Msg,
 	}
 	return r, nil
 }
+
+// MakeNonRecursiveQuery queries the nameserver with the RD bit cleared, as
+// done when talking to authoritative servers. The answer is returned whatever
+// the response code is and the query is retried over TCP when truncated
+func MakeNonRecursiveQuery(c *dns.Client, query, nameserver string, qType uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(query), qType)
+	m.RecursionDesired = false
+	m.SetEdns0(1232, false)
+
+	r, err := Exchange(c, m, nameserver)
+	if err == nil && r.Truncated {
+		r, err = Exchange(&dns.Client{Net: "tcp", Timeout: c.Timeout}, m, nameserver)
+	}
+	return r, err
+}
+
+// MakeDNSSECQuery is MakeNonRecursiveQuery with the DO bit set, so that the
+// answer carries the RRSIG, NSEC and NSEC3 records
+func MakeDNSSECQuery(c *dns.Client, query, nameserver string, qType uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(query), qType)
+	m.RecursionDesired = false
+	m.SetEdns0(1232, true)
+
+	r, err := Exchange(c, m, nameserver)
+	if err == nil && r.Truncated {
+		r, err = Exchange(&dns.Client{Net: "tcp", Timeout: c.Timeout}, m, nameserver)
+	}
+	return r, err
+}
+
+// Exchange sends m to the nameserver and returns its answer, whatever the
+// response code is
+func Exchange(c *dns.Client, m *dns.Msg, nameserver string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	r, _, err := c.ExchangeContext(ctx, m, nameserver)
+	return r, err
+}
+
+// NoLocalRoute tells if the query failed because the scanning host can't
+// reach the address, like an IPv6 address on a host without IPv6
+// connectivity, rather than because of the nameserver
+func NoLocalRoute(err error) bool {
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout() && !errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// MeasureUDP sends a single query over UDP, advertising a buffer of bufsize
+// bytes and the DO bit, and returns the size on the wire of both the query
+// and the answer, without retrying over TCP when truncated
+func MeasureUDP(query, nameserver string, qType, bufsize uint16) (int, int, *dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(query), qType)
+	m.RecursionDesired = false
+	m.SetEdns0(bufsize, true)
+
+	req, err := m.Pack()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	conn, err := net.DialTimeout("udp", nameserver, 2*time.Second)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return 0, 0, nil, err
+	}
+
+	buf := make([]byte, dns.MaxMsgSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf[:n]); err != nil {
+		return 0, 0, nil, err
+	}
+	if r.Id != m.Id {
+		return 0, 0, nil, fmt.Errorf("mismatched answer id from %v", nameserver)
+	}
+	return len(req), n, r, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNoLocalRoute(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "network unreachable",
+			err:  &net.OpError{Op: "dial", Net: "udp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)},
+			want: true,
+		},
+		{
+			name: "no source address",
+			err:  &net.OpError{Op: "dial", Net: "udp", Err: os.NewSyscallError("connect", syscall.EADDRNOTAVAIL)},
+			want: true,
+		},
+		{
+			name: "dial failure",
+			err:  &net.OpError{Op: "dial", Net: "udp", Err: errors.New("socket: address family not supported")},
+			want: true,
+		},
+		{name: "dial timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, want: false},
+		{
+			name: "connection refused",
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: false,
+		},
+		{name: "read timeout", err: &net.OpError{Op: "read", Net: "udp", Err: timeoutError{}}, want: false},
+		{name: "other error", err: errors.New("bad rcode"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := NoLocalRoute(tt.err); got != tt.want {
+			t.Errorf("%v: NoLocalRoute(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
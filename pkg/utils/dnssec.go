@@ -0,0 +1,378 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/miekg/dns"
+)
+
+// DNSSEC validation states as defined in RFC 4033 section 5
+const (
+	DNSSECSecure        = "secure"
+	DNSSECInsecure      = "insecure"
+	DNSSECBogus         = "bogus"
+	DNSSECIndeterminate = "indeterminate"
+)
+
+// ChainLink is a step of the chain of trust: the delegation from Parent to
+// Zone, validated through the DS set of the parent and the DNSKEY set of the
+// zone. Parent is empty for the root, whose DS set is the trust anchor
+type ChainLink struct {
+	Parent string
+	Zone   string
+	Server string
+	State  string
+	Reason string
+}
+
+func (l ChainLink) String() string {
+	parent := "trust anchor"
+	if l.Parent != "" {
+		parent = l.Parent
+	}
+	s := fmt.Sprintf("%v -> %v: %v", parent, l.Zone, l.State)
+	if l.Reason != "" {
+		s += fmt.Sprintf(" (%v)", l.Reason)
+	}
+	return s
+}
+
+// ChainValidation is the outcome of the validation of a zone from the root
+// trust anchor, Keys holds the validated DNSKEY RRset of the zone when secure
+type ChainValidation struct {
+	State string
+	Links []ChainLink
+	Keys  []*dns.DNSKEY
+}
+
+// FailingLink returns the link that made the validation stop, nil when the
+// zone is secure
+func (v *ChainValidation) FailingLink() *ChainLink {
+	if v.State == DNSSECSecure || len(v.Links) == 0 {
+		return nil
+	}
+	return &v.Links[len(v.Links)-1]
+}
+
+// ValidateChain builds the chain of trust from the root to zone, following
+// the referrals as FindDelegation does: at every zone cut the DS set signed by
+// the parent must match a key of the DNSKEY set of the child, which in turn
+// must be signed by that key. Addresses of nameservers without glue are
+// looked up with the resolver
+func ValidateChain(zone string, resolver *Resolver) *ChainValidation {
+	v := new(ChainValidation)
+	target := dns.Fqdn(zone)
+
+	ds, err := rootAnchors()
+	if err != nil {
+		return v.stop(ChainLink{Zone: ".", State: DNSSECIndeterminate, Reason: err.Error()})
+	}
+	current := "."
+	servers := defaults.RootServers
+	link := ChainLink{Zone: current}
+
+	for i := 0; i < maxReferrals; i++ {
+		keys, server, err := validateKeys(current, servers, ds)
+		link.Server = server
+		if err != nil {
+			link.State, link.Reason = chainState(err), err.Error()
+			return v.stop(link)
+		}
+		link.State = DNSSECSecure
+		v.Links = append(v.Links, link)
+		if strings.EqualFold(current, target) {
+			v.State = DNSSECSecure
+			v.Keys = keys
+			return v
+		}
+
+		// The next zone cut is found with the same query used to walk the
+		// delegations, the DS set (or the proof of its absence) comes in
+		// the authority section of the referral
+		r, server, err := queryIterative(MakeDNSSECQuery, servers, target, dns.TypeNS)
+		if err != nil {
+			return v.stop(ChainLink{Parent: current, Zone: target, State: DNSSECIndeterminate, Reason: err.Error()})
+		}
+
+		var cut string
+		var section []dns.RR
+		referral := nsRecords(r.Ns, "")
+		if !r.Authoritative && len(referral) > 0 {
+			cut = referral[0].Hdr.Name
+			section = r.Ns
+			d := &Delegation{Server: server, NS: nsRecords(r.Ns, cut), Glue: glueRecords(r.Extra)}
+			if servers, err = delegationAddresses(d, resolver); err != nil {
+				return v.stop(ChainLink{Parent: current, Zone: cut, Server: server, State: DNSSECIndeterminate, Reason: err.Error()})
+			}
+		} else {
+			// The servers of the parent are authoritative for the child
+			// too, so the DS set has to be asked explicitly
+			cut = target
+			if r, server, err = queryIterative(MakeDNSSECQuery, servers, cut, dns.TypeDS); err != nil {
+				return v.stop(ChainLink{Parent: current, Zone: cut, State: DNSSECIndeterminate, Reason: err.Error()})
+			}
+			section = append(r.Answer, r.Ns...)
+		}
+		if !dns.IsSubDomain(current, cut) || dns.CountLabel(cut) <= dns.CountLabel(current) {
+			reason := fmt.Sprintf("bad referral to %v", cut)
+			return v.stop(ChainLink{Parent: current, Zone: cut, Server: server, State: DNSSECIndeterminate, Reason: reason})
+		}
+
+		link = ChainLink{Parent: current, Zone: cut, Server: server}
+		if ds, err = delegationSigner(current, cut, section, keys); err != nil {
+			link.State, link.Reason = chainState(err), err.Error()
+			return v.stop(link)
+		}
+		current = cut
+	}
+	return v.stop(ChainLink{Zone: target, State: DNSSECIndeterminate, Reason: "too many referrals"})
+}
+
+// stop ends the validation with the state of the failing link
+func (v *ChainValidation) stop(link ChainLink) *ChainValidation {
+	v.Links = append(v.Links, link)
+	v.State = link.State
+	return v
+}
+
+// VerifyRRset checks that one of the signatures over rrset is made by one of
+// the keys and is within its validity period
+func VerifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) error {
+	if len(rrset) == 0 {
+		return fmt.Errorf("empty RRset")
+	}
+	name := rrset[0].Header().Name
+	qType := dns.TypeToString[rrset[0].Header().Rrtype]
+	if len(sigs) == 0 {
+		return fmt.Errorf("no RRSIG over %v %v", name, qType)
+	}
+
+	var lastErr error
+	for _, sig := range sigs {
+		for _, key := range keys {
+			if sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm || !strings.EqualFold(sig.SignerName, key.Hdr.Name) {
+				continue
+			}
+			if err := sig.Verify(key, rrset); err != nil {
+				lastErr = fmt.Errorf("RRSIG over %v %v by key %d does not verify: %v", name, qType, sig.KeyTag, err)
+				continue
+			}
+			if !sig.ValidityPeriod(time.Now()) {
+				lastErr = fmt.Errorf("RRSIG over %v %v by key %d is outside its validity period (%v to %v)", name, qType, sig.KeyTag,
+					dns.TimeToString(sig.Inception), dns.TimeToString(sig.Expiration))
+				continue
+			}
+			return nil
+		}
+	}
+	if lastErr == nil {
+		var tags []string
+		for _, sig := range sigs {
+			tags = append(tags, fmt.Sprint(sig.KeyTag))
+		}
+		lastErr = fmt.Errorf("no RRSIG over %v %v is made by a trusted key (key tags %v)", name, qType, strings.Join(tags, ", "))
+	}
+	return lastErr
+}
+
+// ExtractRRset returns the records of type qType owned by owner
+func ExtractRRset(rrs []dns.RR, owner string, qType uint16) []dns.RR {
+	var rrset []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qType && strings.EqualFold(rr.Header().Name, dns.Fqdn(owner)) {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset
+}
+
+// ExtractRRSIGs returns the signatures owned by owner covering qType
+func ExtractRRSIGs(rrs []dns.RR, owner string, covered uint16) []*dns.RRSIG {
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == covered && strings.EqualFold(sig.Hdr.Name, dns.Fqdn(owner)) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// dnssecError is a validation failure that makes the chain bogus, other
+// errors (timeouts, broken answers) leave it indeterminate
+type dnssecError struct {
+	state string
+	msg   string
+}
+
+func (e *dnssecError) Error() string {
+	return e.msg
+}
+
+func bogus(format string, a ...interface{}) error {
+	return &dnssecError{state: DNSSECBogus, msg: fmt.Sprintf(format, a...)}
+}
+
+func chainState(err error) string {
+	if e, ok := err.(*dnssecError); ok {
+		return e.state
+	}
+	return DNSSECIndeterminate
+}
+
+func rootAnchors() ([]*dns.DS, error) {
+	var anchors []*dns.DS
+	for _, s := range defaults.RootTrustAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor: %v", err)
+		}
+		anchors = append(anchors, rr.(*dns.DS))
+	}
+	return anchors, nil
+}
+
+// validateKeys fetches the DNSKEY RRset of zone and validates it against the
+// DS set of the parent
+func validateKeys(zone string, servers []string, ds []*dns.DS) ([]*dns.DNSKEY, string, error) {
+	r, server, err := queryIterative(MakeDNSSECQuery, servers, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rrset := ExtractRRset(r.Answer, zone, dns.TypeDNSKEY)
+	if len(rrset) == 0 {
+		return nil, server, bogus("%v has a DS set in the parent zone but no DNSKEY", zone)
+	}
+	var keys, trusted []*dns.DNSKEY
+	for _, rr := range rrset {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+	for _, key := range keys {
+		if matchDS(key, ds) {
+			trusted = append(trusted, key)
+		}
+	}
+	if len(trusted) == 0 {
+		var tags []string
+		for _, d := range ds {
+			tags = append(tags, fmt.Sprint(d.KeyTag))
+		}
+		return nil, server, bogus("no DNSKEY of %v matches the DS records (key tags %v)", zone, strings.Join(tags, ", "))
+	}
+
+	if err := VerifyRRset(rrset, ExtractRRSIGs(r.Answer, zone, dns.TypeDNSKEY), trusted); err != nil {
+		return nil, server, bogus("%v", err)
+	}
+	return keys, server, nil
+}
+
+// matchDS tells if the zone key is the one referenced by one of the DS
+func matchDS(key *dns.DNSKEY, ds []*dns.DS) bool {
+	if key.Flags&dns.ZONE == 0 {
+		return false
+	}
+	for _, d := range ds {
+		if d.KeyTag != key.KeyTag() || d.Algorithm != key.Algorithm {
+			continue
+		}
+		if computed := key.ToDS(d.DigestType); computed != nil && strings.EqualFold(computed.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// delegationSigner validates the DS set of cut found in rrs with the keys
+// of the parent zone. When there is no DS set, its absence must be proven
+// by a signed NSEC or NSEC3 record and the delegation is insecure
+func delegationSigner(parent, cut string, rrs []dns.RR, keys []*dns.DNSKEY) ([]*dns.DS, error) {
+	rrset := ExtractRRset(rrs, cut, dns.TypeDS)
+	if len(rrset) == 0 {
+		if err := verifyNoDS(cut, rrs, keys); err != nil {
+			return nil, err
+		}
+		return nil, &dnssecError{state: DNSSECInsecure, msg: fmt.Sprintf("%v publishes no DS record for %v", parent, cut)}
+	}
+
+	if err := VerifyRRset(rrset, ExtractRRSIGs(rrs, cut, dns.TypeDS), keys); err != nil {
+		return nil, bogus("%v", err)
+	}
+	var ds []*dns.DS
+	for _, rr := range rrset {
+		ds = append(ds, rr.(*dns.DS))
+	}
+	return ds, nil
+}
+
+// verifyNoDS looks for the signed NSEC or NSEC3 record proving that cut has
+// no DS record (RFC 4035 section 5.2 and RFC 5155 section 8.9)
+func verifyNoDS(cut string, rrs []dns.RR, keys []*dns.DNSKEY) error {
+	for _, rr := range rrs {
+		switch t := rr.(type) {
+		case *dns.NSEC:
+			if !strings.EqualFold(t.Hdr.Name, dns.Fqdn(cut)) {
+				continue
+			}
+			if hasType(t.TypeBitMap, dns.TypeDS) {
+				return bogus("the NSEC record of %v claims that a DS set exists", cut)
+			}
+			if err := VerifyRRset([]dns.RR{t}, ExtractRRSIGs(rrs, t.Hdr.Name, dns.TypeNSEC), keys); err != nil {
+				return bogus("%v", err)
+			}
+			return nil
+		case *dns.NSEC3:
+			// an opt-out span covering the name is enough for an
+			// unsigned delegation
+			matched := t.Match(cut)
+			if !matched && !(t.Cover(cut) && t.Flags&1 == 1) {
+				continue
+			}
+			if matched && hasType(t.TypeBitMap, dns.TypeDS) {
+				return bogus("the NSEC3 record of %v claims that a DS set exists", cut)
+			}
+			if err := VerifyRRset([]dns.RR{t}, ExtractRRSIGs(rrs, t.Hdr.Name, dns.TypeNSEC3), keys); err != nil {
+				return bogus("%v", err)
+			}
+			return nil
+		}
+	}
+	return bogus("no DS record for %v and no NSEC or NSEC3 record proving its absence", cut)
+}
+
+func hasType(bitmap []uint16, qType uint16) bool {
+	for _, t := range bitmap {
+		if t == qType {
+			return true
+		}
+	}
+	return false
+}
+
+// RSAKeySize returns the size in bits of the modulus of an RSA DNSKEY, 0 for
+// keys of other algorithms or that can't be decoded (RFC 3110 section 2)
+func RSAKeySize(key *dns.DNSKEY) int {
+	switch key.Algorithm {
+	case dns.RSAMD5, dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+	default:
+		return 0
+	}
+	buf, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(buf) < 3 {
+		return 0
+	}
+
+	// the exponent length takes one byte, or three when the first is 0
+	expLen, off := int(buf[0]), 1
+	if expLen == 0 {
+		expLen, off = int(buf[1])<<8|int(buf[2]), 3
+	}
+	if off+expLen >= len(buf) {
+		return 0
+	}
+	return new(big.Int).SetBytes(buf[off+expLen:]).BitLen()
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NormalizeDomain validates the domain provided by the user and returns it
+// lowercase and without the trailing dot. Public suffixes (com, co.uk, ...)
+// are rejected since there is no zone of an organization to assess
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if _, ok := dns.IsDomainName(domain); !ok || !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("invalid domain: %v", domain)
+	}
+	if _, err := RegistrableDomain(domain); err != nil {
+		return "", fmt.Errorf("%v is a public suffix, please provide a domain registered under it", domain)
+	}
+	return domain, nil
+}
+
+// RegistrableDomain returns the domain registered under the public suffix,
+// for example "example.co.uk" for "mail.example.co.uk"
+func RegistrableDomain(domain string) (string, error) {
+	return publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(domain, "."))
+}
+
+// OrganizationalName returns the label of the organization that registered
+// the domain, for example "example" for "mail.example.co.uk"
+func OrganizationalName(domain string) string {
+	registrable, err := RegistrableDomain(domain)
+	if err != nil {
+		return ""
+	}
+	return strings.Split(registrable, ".")[0]
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// RouteObject is the subset of an RPSL route/route6 object (RFC 2622) that
+// is needed to match a BGP announcement
+type RouteObject struct {
+	Prefix *net.IPNet
+	Origin uint32
+	Source string
+}
+
+func (r RouteObject) String() string {
+	if r.Source != "" {
+		return fmt.Sprintf("%v AS%d (%v)", r.Prefix, r.Origin, r.Source)
+	}
+	return fmt.Sprintf("%v AS%d", r.Prefix, r.Origin)
+}
+
+// QueryIRR asks a whois-style IRR server (host or host:port) for every route
+// object that is equal to or less specific than prefix
+func QueryIRR(server string, prefix *net.IPNet) ([]RouteObject, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "43")
+	}
+
+	conn, err := net.DialTimeout("tcp", server, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(conn, "-T route,route6 -L %v\r\n", prefix); err != nil {
+		return nil, err
+	}
+	return parseRPSL(conn)
+}
+
+// LoadRPSL reads the route objects of a local RPSL dump, like the ones
+// published by the IRR databases (radb.db, ripe.db.route, ...)
+func LoadRPSL(path string) ([]RouteObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRPSL(f)
+}
+
+// CoveringRoutes returns the route objects that are equal to or less
+// specific than prefix
+func CoveringRoutes(routes []RouteObject, prefix *net.IPNet) []RouteObject {
+	var covering []RouteObject
+	for _, r := range routes {
+		if coversPrefix(r.Prefix, prefix) {
+			covering = append(covering, r)
+		}
+	}
+	return covering
+}
+
+func parseRPSL(r io.Reader) ([]RouteObject, error) {
+	var routes []RouteObject
+	var attrs map[string]string
+
+	flush := func() {
+		defer func() { attrs = nil }()
+		if attrs == nil {
+			return
+		}
+		p, ok := attrs["route"]
+		if !ok {
+			p = attrs["route6"]
+		}
+		_, prefix, err := net.ParseCIDR(p)
+		if err != nil {
+			return
+		}
+		origin, err := ParseASN(attrs["origin"])
+		if err != nil {
+			return
+		}
+		routes = append(routes, RouteObject{Prefix: prefix, Origin: origin, Source: attrs["source"]})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case strings.HasPrefix(line, "%"), strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, "\t"), strings.HasPrefix(line, "+"):
+			// continuation lines are irrelevant for the attributes we use
+			continue
+		default:
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			if attrs == nil {
+				attrs = make(map[string]string)
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			// keep the first occurrence, only the object class and
+			// origin are single-valued attributes we rely on
+			if _, ok := attrs[key]; !ok {
+				attrs[key] = strings.TrimSpace(strings.SplitN(value, "#", 2)[0])
+			}
+		}
+	}
+	flush()
+	return routes, scanner.Err()
+}
@@ -3,66 +3,80 @@ package utils
 import (
 	"fmt"
 	"net"
-	"strings"
-
-	"github.com/5amu/dnshunter/pkg/defaults"
-	"github.com/miekg/dns"
 )
 
 type Nameservers struct {
-	IPs      []net.IP
-	FQDNs    []string
-	fqdnToIP map[string]net.IP
+	// Zone is the DNS zone containing the scanned domain, it differs from
+	// the domain only when the latter is not delegated on its own
+	Zone string
+	// IPs holds the IPv4 and IPv6 addresses of all the nameservers
+	IPs   []net.IP
+	FQDNs []string
+	// Parent is the NS set (and glue) published by the parent zone, it is
+	// nil when the parent is served by the same nameservers as the zone
+	Parent *Delegation
+	// Child is the NS set answered by the nameservers of the zone
+	Child *Delegation
+	// Unresolved maps the nameservers of the zone whose address could not
+	// be found to the lookup error, they are left out of FQDNs
+	Unresolved map[string]error
+	// Resolver is the set of upstream resolvers that checks should use
+	// for recursive queries
+	Resolver  *Resolver
+	fqdnToIPs map[string][]net.IP
 }
 
-func NewNameserversFromDomain(domain string) (*Nameservers, error) {
-	r, err := MakeQuery(new(dns.Client), dns.Fqdn(domain), net.JoinHostPort(defaults.DefaultNameserver, "53"), dns.TypeNS)
+// NewNameserversFromDomain discovers the nameservers of the zone containing
+// domain by walking the delegations from the root, the checks are run
+// against the NS set published by the zone itself
+func NewNameserversFromDomain(domain string, resolver *Resolver) (*Nameservers, error) {
+	zone, parent, child, err := FindDelegation(domain, resolver)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []string
-	for _, r := range r.Answer {
-		switch t := r.(type) {
-		case *dns.NS:
-			result = append(result, strings.Trim(t.Ns, "."))
-		}
+	n := &Nameservers{
+		Zone:     zone,
+		FQDNs:    child.Names(),
+		Parent:   parent,
+		Child:    child,
+		Resolver: resolver,
 	}
-
-	n := &Nameservers{FQDNs: result}
 	if err := n.prepare(); err != nil {
 		return nil, err
 	}
 	return n, nil
 }
 
-func (n *Nameservers) GetIP(fqdn string) net.IP {
-	return n.fqdnToIP[fqdn]
+// GetIPs returns every IPv4 and IPv6 address of the nameserver
+func (n *Nameservers) GetIPs(fqdn string) []net.IP {
+	return n.fqdnToIPs[fqdn]
 }
 
-func (n *Nameservers) prepare() (err error) {
-	client := new(dns.Client)
-	n.fqdnToIP = make(map[string]net.IP)
+// prepare looks up the addresses of every nameserver, the ones that can't be
+// resolved are kept aside so that the checks run against the others
+func (n *Nameservers) prepare() error {
+	var resolved []string
+	n.fqdnToIPs = make(map[string][]net.IP)
+	n.Unresolved = make(map[string]error)
 	for _, fqdn := range n.FQDNs {
-		if n.fqdnToIP[fqdn], err = nsToIPv4(client, fqdn); err != nil {
-			return err
+		ips, err := n.Resolver.LookupIPs(fqdn)
+		if err != nil {
+			n.Unresolved[fqdn] = err
+			continue
 		}
-		n.IPs = append(n.IPs, n.fqdnToIP[fqdn])
+		n.fqdnToIPs[fqdn] = ips
+		n.IPs = append(n.IPs, ips...)
+		resolved = append(resolved, fqdn)
 	}
+	if len(resolved) == 0 {
+		return fmt.Errorf("unable to resolve any nameserver of %v: %v", n.Zone, n.FQDNs)
+	}
+	n.FQDNs = resolved
 	return nil
 }
 
-func nsToIPv4(client *dns.Client, fqdn string) (net.IP, error) {
-	r, err := MakeQuery(client, dns.Fqdn(fqdn), net.JoinHostPort(defaults.DefaultNameserver, "53"), dns.TypeA)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, r := range r.Answer {
-		switch t := r.(type) {
-		case *dns.A:
-			return t.A, nil
-		}
-	}
-	return nil, fmt.Errorf("no IPv4 address found for %v", fqdn)
+// IsIPv6 tells if ip is an IPv6 address
+func IsIPv6(ip net.IP) bool {
+	return ip.To4() == nil
 }
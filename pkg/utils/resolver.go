@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/5amu/dnshunter/pkg/defaults"
+	"github.com/miekg/dns"
+)
+
+// ErrNXDomain is returned by Query when the name does not exist
+var ErrNXDomain = errors.New("NXDOMAIN")
+
+// Resolver sends recursive queries to the configured upstream resolvers,
+// failing over to the next one when a server does not give a usable answer
+type Resolver struct {
+	Servers []string
+	client  *dns.Client
+}
+
+// NewResolver returns a Resolver using the given servers (ip or ip:port),
+// the default nameserver is used when none is provided
+func NewResolver(servers []string) (*Resolver, error) {
+	if len(servers) == 0 {
+		servers = []string{defaults.DefaultNameserver}
+	}
+
+	r := &Resolver{client: new(dns.Client)}
+	for _, s := range servers {
+		addr, err := withDefaultPort(s, "53")
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolver %v: %v", s, err)
+		}
+		r.Servers = append(r.Servers, addr)
+	}
+	return r, nil
+}
+
+// NewResolverFromConf returns a Resolver using the nameservers listed in a
+// resolv.conf(5) file, like /etc/resolv.conf
+func NewResolverFromConf(path string) (*Resolver, error) {
+	conf, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Servers) == 0 {
+		return nil, fmt.Errorf("no nameserver found in %v", path)
+	}
+
+	var servers []string
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+	return NewResolver(servers)
+}
+
+// Query asks the upstream resolvers for the records of the given type.
+// Servers that can't be reached, fail or refuse the query are skipped, any
+// other answer different from NOERROR is returned as an error. Truncated
+// answers are retried over TCP on the same server
+func (r *Resolver) Query(query string, qType uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.RecursionDesired = true
+	m.SetQuestion(dns.Fqdn(query), qType)
+	m.SetEdns0(1232, false)
+
+	var lastErr error
+	for _, server := range r.Servers {
+		res, err := Exchange(r.client, m, server)
+		if err == nil && res.Truncated {
+			res, err = Exchange(&dns.Client{Net: "tcp", Timeout: r.client.Timeout}, m, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.Rcode == dns.RcodeServerFailure || res.Rcode == dns.RcodeRefused {
+			lastErr = fmt.Errorf("%v answered %v for %v", server, dns.RcodeToString[res.Rcode], query)
+			continue
+		}
+		if res.Rcode == dns.RcodeNameError {
+			return nil, fmt.Errorf("%w from %v after query for %v", ErrNXDomain, server, query)
+		}
+		if res.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("invalid answer from %v after query for %v", server, query)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("no resolver answered the query for %v: %v", query, lastErr)
+}
+
+// LookupIPs returns all the A and AAAA records of the host
+func (r *Resolver) LookupIPs(fqdn string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		res, err := r.Query(fqdn, qType)
+		if err != nil {
+			continue
+		}
+		for _, a := range res.Answer {
+			switch t := a.(type) {
+			case *dns.A:
+				ips = append(ips, t.A)
+			case *dns.AAAA:
+				ips = append(ips, t.AAAA)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address found for %v", fqdn)
+	}
+	return ips, nil
+}
+
+// withDefaultPort adds port to addr when it does not specify one, IPv6
+// addresses can be written with or without brackets
+func withDefaultPort(addr, port string) (string, error) {
+	if host, p, err := net.SplitHostPort(addr); err == nil {
+		if net.ParseIP(host) == nil {
+			return "", fmt.Errorf("not an IP address")
+		}
+		return net.JoinHostPort(host, p), nil
+	}
+	addr = strings.Trim(addr, "[]")
+	if net.ParseIP(addr) == nil {
+		return "", fmt.Errorf("not an IP address")
+	}
+	return net.JoinHostPort(addr, port), nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// fakeResolver returns a Resolver whose only upstream serves the records of
+// zone, written in master file format. Names without records are NXDOMAIN
+// and CNAME chains are followed like a recursive resolver does
+func fakeResolver(t *testing.T, zone string) *Resolver {
+	t.Helper()
+	records := make(map[string][]dns.RR)
+	zp := dns.NewZoneParser(strings.NewReader(zone), ".", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		records[name] = append(records[name], rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.RecursionAvailable = true
+		q := req.Question[0]
+		name := strings.ToLower(q.Name)
+		for hops := 0; hops < 8; hops++ {
+			rrs, ok := records[name]
+			if !ok {
+				if len(m.Answer) == 0 {
+					m.Rcode = dns.RcodeNameError
+				}
+				break
+			}
+			var target string
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == q.Qtype {
+					m.Answer = append(m.Answer, rr)
+				} else if cname, ok := rr.(*dns.CNAME); ok {
+					m.Answer = append(m.Answer, rr)
+					target = strings.ToLower(cname.Target)
+				}
+			}
+			if target == "" || q.Qtype == dns.TypeCNAME {
+				break
+			}
+			name = target
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	r, err := NewResolver([]string{pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResolverQuery(t *testing.T) {
+	r := fakeResolver(t, `
+example.com. 300 IN A 192.0.2.1
+www.example.com. 300 IN CNAME example.com.
+`)
+
+	tests := []struct {
+		name    string
+		qType   uint16
+		answers int
+		nx      bool
+	}{
+		{name: "example.com", qType: dns.TypeA, answers: 1},
+		{name: "example.com", qType: dns.TypeTXT, answers: 0},
+		{name: "www.example.com", qType: dns.TypeA, answers: 2},
+		{name: "missing.example.com", qType: dns.TypeA, nx: true},
+	}
+
+	for _, tt := range tests {
+		res, err := r.Query(tt.name, tt.qType)
+		if tt.nx {
+			if !errors.Is(err, ErrNXDomain) {
+				t.Errorf("Query(%v): err = %v, want NXDOMAIN", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Query(%v): %v", tt.name, err)
+			continue
+		}
+		if len(res.Answer) != tt.answers {
+			t.Errorf("Query(%v, %v) = %d answers, want %d", tt.name, dns.TypeToString[tt.qType], len(res.Answer), tt.answers)
+		}
+	}
+}
@@ -0,0 +1,790 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SPF results as defined in RFC 7208 section 2.6
+const (
+	SPFNone      = "none"
+	SPFNeutral   = "neutral"
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftFail  = "softfail"
+	SPFTempError = "temperror"
+	SPFPermError = "permerror"
+)
+
+const (
+	// spfLookupLimit is the number of mechanisms and modifiers causing DNS
+	// queries allowed during an evaluation (RFC 7208 section 4.6.4)
+	spfLookupLimit = 10
+	// spfVoidLimit is the number of lookups returning no records allowed
+	// during an evaluation (RFC 7208 section 4.6.4)
+	spfVoidLimit = 2
+	// spfNameLimit is the number of MX or PTR names looked up for a
+	// single mechanism (RFC 7208 section 4.6.4)
+	spfNameLimit = 10
+)
+
+var spfModifier = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*=`)
+
+// spfDualCIDR matches the optional ip4-cidr-length and ip6-cidr-length at the
+// end of the argument of the a and mx mechanisms
+var spfDualCIDR = regexp.MustCompile(`(?:/(\d+))?(?://(\d+))?$`)
+
+// SPFTerm is a mechanism of an SPF record, Value is the domain-spec or the
+// network of ip4 and ip6. CIDR4 and CIDR6 are -1 when not specified
+type SPFTerm struct {
+	Qualifier byte
+	Name      string
+	Value     string
+	CIDR4     int
+	CIDR6     int
+}
+
+func (t SPFTerm) String() string {
+	s := t.Name
+	if t.Qualifier != '+' {
+		s = string(t.Qualifier) + s
+	}
+	if t.Value != "" {
+		s += ":" + t.Value
+	}
+	if t.CIDR4 >= 0 {
+		s += fmt.Sprintf("/%d", t.CIDR4)
+	}
+	if t.CIDR6 >= 0 {
+		s += fmt.Sprintf("//%d", t.CIDR6)
+	}
+	return s
+}
+
+// SPFRecord is a parsed SPF record, Mechanisms are kept in order and the
+// known modifiers are split out
+type SPFRecord struct {
+	Raw        string
+	Mechanisms []SPFTerm
+	Redirect   string
+	Exp        string
+}
+
+// ParseSPF parses an SPF record, any syntax error is a PermError (RFC 7208
+// section 4.6)
+func ParseSPF(record string) (*SPFRecord, error) {
+	fields := strings.Fields(record)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "v=spf1") {
+		return nil, fmt.Errorf("not an SPF record: %q", record)
+	}
+
+	spf := &SPFRecord{Raw: record}
+	var hasRedirect, hasExp bool
+	for _, f := range fields[1:] {
+		if spfModifier.MatchString(f) {
+			eq := strings.IndexByte(f, '=')
+			name, value := strings.ToLower(f[:eq]), f[eq+1:]
+			switch name {
+			case "redirect", "exp":
+				if err := validateMacro(value); err != nil {
+					return nil, err
+				}
+				if name == "redirect" {
+					if hasRedirect {
+						return nil, fmt.Errorf("redirect modifier appears more than once")
+					}
+					hasRedirect, spf.Redirect = true, value
+				} else {
+					if hasExp {
+						return nil, fmt.Errorf("exp modifier appears more than once")
+					}
+					hasExp, spf.Exp = true, value
+				}
+			}
+			// unknown modifiers must be ignored (RFC 7208 section 6)
+			continue
+		}
+
+		t, err := parseMechanism(f)
+		if err != nil {
+			return nil, err
+		}
+		spf.Mechanisms = append(spf.Mechanisms, *t)
+	}
+	return spf, nil
+}
+
+func parseMechanism(f string) (*SPFTerm, error) {
+	t := &SPFTerm{Qualifier: '+', CIDR4: -1, CIDR6: -1}
+	if strings.ContainsRune("+-~?", rune(f[0])) {
+		t.Qualifier, f = f[0], f[1:]
+	}
+	end := strings.IndexAny(f, ":/")
+	if end < 0 {
+		end = len(f)
+	}
+	t.Name, f = strings.ToLower(f[:end]), f[end:]
+
+	switch t.Name {
+	case "all":
+		if f != "" {
+			return nil, fmt.Errorf("all takes no argument: all%v", f)
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(f, ":") || len(f) == 1 {
+			return nil, fmt.Errorf("%v requires a domain", t.Name)
+		}
+		t.Value = f[1:]
+	case "a", "mx", "ptr":
+		if t.Name != "ptr" {
+			m := spfDualCIDR.FindStringSubmatch(f)
+			f = strings.TrimSuffix(f, m[0])
+			var err error
+			if t.CIDR4, err = cidrLength(m[1], 32); err != nil {
+				return nil, err
+			}
+			if t.CIDR6, err = cidrLength(m[2], 128); err != nil {
+				return nil, err
+			}
+		}
+		if f != "" {
+			if !strings.HasPrefix(f, ":") || len(f) == 1 {
+				return nil, fmt.Errorf("invalid %v mechanism: %v%v", t.Name, t.Name, f)
+			}
+			t.Value = f[1:]
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(f, ":") {
+			return nil, fmt.Errorf("%v requires a network", t.Name)
+		}
+		t.Value = f[1:]
+		if _, err := t.Network(); err != nil {
+			return nil, err
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unknown mechanism: %v", t.Name)
+	}
+
+	if err := validateMacro(t.Value); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func cidrLength(s string, max int) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > max || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("invalid CIDR length: /%v", s)
+	}
+	return n, nil
+}
+
+// Network returns the network of an ip4 or ip6 mechanism
+func (t SPFTerm) Network() (*net.IPNet, error) {
+	addr, bits := t.Value, 32
+	if t.Name == "ip6" {
+		bits = 128
+	}
+	length := bits
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		var err error
+		if length, err = cidrLength(addr[i+1:], bits); err != nil {
+			return nil, err
+		}
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || (t.Name == "ip4" && ip.To4() == nil) || (t.Name == "ip6" && ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid %v network: %v", t.Name, t.Value)
+	}
+	if t.Name == "ip4" {
+		ip = ip.To4()
+	}
+	mask := net.CIDRMask(length, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// spfError carries the result of an evaluation that ended with an error
+type spfError struct {
+	result   string
+	msg      string
+	reported bool
+}
+
+func (e *spfError) Error() string {
+	return e.msg
+}
+
+func permError(format string, a ...interface{}) error {
+	return &spfError{result: SPFPermError, msg: fmt.Sprintf(format, a...)}
+}
+
+// SPFEvaluator implements the check_host() function of RFC 7208 section 4,
+// recording along the way the issues found in the evaluated records. A new
+// evaluator has to be used for every evaluation, since the lookup limits
+// apply to the evaluation as a whole
+type SPFEvaluator struct {
+	Resolver *Resolver
+	// Lookups and Voids are the DNS lookups and the void lookups counted
+	// against the limits of RFC 7208 section 4.6.4
+	Lookups int
+	Voids   int
+	// Findings are the issues found in the records, one per line
+	Findings []string
+	// Trace is the tree of the evaluated records, indented by depth
+	Trace []string
+
+	ip     net.IP
+	sender string
+	helo   string
+}
+
+// NewSPFEvaluator returns an evaluator for mail sent by sender from ip
+func NewSPFEvaluator(resolver *Resolver, ip net.IP, sender, helo string) *SPFEvaluator {
+	return &SPFEvaluator{Resolver: resolver, ip: ip, sender: sender, helo: helo}
+}
+
+// CheckHost looks up the SPF record of domain and evaluates it
+func (e *SPFEvaluator) CheckHost(domain string) (string, error) {
+	return e.checkHost(domain, 0, true)
+}
+
+// CheckRecord evaluates an SPF record that has already been retrieved for
+// domain, with SelectSPF for instance
+func (e *SPFEvaluator) CheckRecord(record, domain string) (string, error) {
+	return e.evaluate(record, domain, 0, true)
+}
+
+// SelectSPF returns the SPF record among the TXT records of a domain, more
+// than one SPF record is a PermError (RFC 7208 section 4.5)
+func SelectSPF(rrs []dns.RR) (string, error) {
+	var records []string
+	for _, rr := range rrs {
+		t, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		txt := strings.Join(t.Txt, "")
+		if strings.EqualFold(txt, "v=spf1") || strings.HasPrefix(strings.ToLower(txt), "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+	if len(records) > 1 {
+		return "", permError("%d SPF records published, only one is allowed", len(records))
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+	return records[0], nil
+}
+
+func (e *SPFEvaluator) finding(domain, format string, a ...interface{}) {
+	e.Findings = append(e.Findings, fmt.Sprintf("%v: %v", domain, fmt.Sprintf(format, a...)))
+}
+
+// checkHost evaluates the record of domain, top tells if the result is the
+// one of the whole evaluation (top level record or redirect) rather than
+// the one of an include
+func (e *SPFEvaluator) checkHost(domain string, depth int, top bool) (string, error) {
+	rrs, err := e.lookup(domain, dns.TypeTXT, false)
+	if err != nil {
+		return e.fail(domain, err)
+	}
+	record, err := SelectSPF(rrs)
+	if err != nil {
+		return e.fail(domain, err)
+	}
+	if record == "" {
+		e.trace(depth, "%v: no SPF record", domain)
+		return SPFNone, nil
+	}
+	return e.evaluate(record, domain, depth, top)
+}
+
+// fail turns an error into the corresponding result, recording the finding
+func (e *SPFEvaluator) fail(domain string, err error) (string, error) {
+	var se *spfError
+	if errors.As(err, &se) {
+		// the error goes up through the includes, it is reported once
+		// on the record where it happened
+		if !se.reported {
+			se.reported = true
+			e.finding(domain, "%v (%v)", se.msg, se.result)
+		}
+		return se.result, err
+	}
+	return SPFTempError, err
+}
+
+func (e *SPFEvaluator) trace(depth int, format string, a ...interface{}) {
+	e.Trace = append(e.Trace, strings.Repeat("    ", depth)+fmt.Sprintf(format, a...))
+}
+
+func (e *SPFEvaluator) evaluate(record, domain string, depth int, top bool) (string, error) {
+	e.trace(depth, "%v: %v", domain, record)
+	spf, err := ParseSPF(record)
+	if err != nil {
+		return e.fail(domain, permError("syntax error: %v", err))
+	}
+	e.audit(spf, domain, top)
+
+	for _, m := range spf.Mechanisms {
+		match, err := e.match(m, domain, depth)
+		if err != nil {
+			return e.fail(domain, err)
+		}
+		if match {
+			return qualifierResult(m.Qualifier), nil
+		}
+	}
+
+	if spf.Redirect == "" {
+		return SPFNeutral, nil
+	}
+	target, err := e.expandDomain(spf.Redirect, domain)
+	if err != nil {
+		return e.fail(domain, err)
+	}
+	if err := e.countLookup(); err != nil {
+		return e.fail(domain, err)
+	}
+	result, err := e.checkHost(target, depth+1, top)
+	if result == SPFNone {
+		return e.fail(domain, permError("redirect to %v, which has no SPF record", target))
+	}
+	return result, err
+}
+
+// audit records the issues of a record that do not depend on the evaluation
+func (e *SPFEvaluator) audit(spf *SPFRecord, domain string, top bool) {
+	var all *SPFTerm
+	for i, m := range spf.Mechanisms {
+		if m.Name == "ptr" {
+			e.finding(domain, "the ptr mechanism is deprecated and should not be used (RFC 7208 section 5.5)")
+		}
+		if all != nil {
+			e.finding(domain, "%v comes after all and is never evaluated", m)
+		}
+		if m.Name == "all" && all == nil {
+			all = &spf.Mechanisms[i]
+		}
+	}
+	if all != nil && spf.Redirect != "" {
+		e.finding(domain, "redirect=%v is ignored since the record has an all mechanism", spf.Redirect)
+	}
+
+	// the all of included records only decides that they do not match,
+	// the qualifier matters for the top level record and its redirects
+	if !top {
+		return
+	}
+	switch {
+	case all == nil && spf.Redirect == "":
+		e.finding(domain, "no all mechanism nor redirect, mail from any other host is neutral")
+	case all == nil:
+	case all.Qualifier == '+':
+		e.finding(domain, "+all authorizes any host to send mail for the domain")
+	case all.Qualifier == '?':
+		e.finding(domain, "?all makes mail from any other host neutral")
+	case all.Qualifier == '~':
+		e.finding(domain, "~all only soft-fails mail from any other host")
+	}
+}
+
+func qualifierResult(q byte) string {
+	switch q {
+	case '-':
+		return SPFFail
+	case '~':
+		return SPFSoftFail
+	case '?':
+		return SPFNeutral
+	default:
+		return SPFPass
+	}
+}
+
+func (e *SPFEvaluator) countLookup() error {
+	e.Lookups++
+	if e.Lookups > spfLookupLimit {
+		return permError("more than %d DNS lookups are needed to evaluate the record", spfLookupLimit)
+	}
+	return nil
+}
+
+// lookup returns the records of type qType of name, the answers without
+// records are void lookups, which are counted when void is set
+func (e *SPFEvaluator) lookup(name string, qType uint16, void bool) ([]dns.RR, error) {
+	r, err := e.Resolver.Query(name, qType)
+	if err != nil && !errors.Is(err, ErrNXDomain) {
+		return nil, err
+	}
+
+	var rrs []dns.RR
+	if err == nil {
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == qType {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	if len(rrs) == 0 && void {
+		e.Voids++
+		if e.Voids > spfVoidLimit {
+			return nil, permError("more than %d DNS lookups returned no records (void lookups)", spfVoidLimit)
+		}
+	}
+	return rrs, nil
+}
+
+// lookupIPs returns the addresses of name in the family of the client
+func (e *SPFEvaluator) lookupIPs(name string, void bool) ([]net.IP, error) {
+	qType := dns.TypeA
+	if e.ip.To4() == nil {
+		qType = dns.TypeAAAA
+	}
+	rrs, err := e.lookup(name, qType, void)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, rr := range rrs {
+		switch t := rr.(type) {
+		case *dns.A:
+			ips = append(ips, t.A)
+		case *dns.AAAA:
+			ips = append(ips, t.AAAA)
+		}
+	}
+	return ips, nil
+}
+
+func (e *SPFEvaluator) match(m SPFTerm, domain string, depth int) (bool, error) {
+	switch m.Name {
+	case "all":
+		return true, nil
+	case "ip4", "ip6":
+		network, _ := m.Network()
+		return network.Contains(e.ip), nil
+	}
+
+	if err := e.countLookup(); err != nil {
+		return false, err
+	}
+	target := domain
+	if m.Value != "" {
+		var err error
+		if target, err = e.expandDomain(m.Value, domain); err != nil {
+			return false, err
+		}
+	}
+
+	switch m.Name {
+	case "include":
+		result, err := e.checkHost(target, depth+1, false)
+		switch result {
+		case SPFPass:
+			return true, nil
+		case SPFFail, SPFSoftFail, SPFNeutral:
+			return false, nil
+		case SPFNone:
+			return false, permError("include:%v has no SPF record", target)
+		default:
+			return false, err
+		}
+	case "a":
+		ips, err := e.lookupIPs(target, true)
+		if err != nil {
+			return false, err
+		}
+		return e.matchIPs(ips, m), nil
+	case "mx":
+		rrs, err := e.lookup(target, dns.TypeMX, true)
+		if err != nil {
+			return false, err
+		}
+		if len(rrs) > spfNameLimit {
+			return false, permError("mx:%v has more than %d MX records", target, spfNameLimit)
+		}
+		for _, rr := range rrs {
+			ips, err := e.lookupIPs(rr.(*dns.MX).Mx, false)
+			if err != nil {
+				return false, err
+			}
+			if e.matchIPs(ips, m) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "ptr":
+		names, err := e.validatedNames()
+		if err != nil {
+			return false, err
+		}
+		for _, n := range names {
+			if dns.IsSubDomain(dns.Fqdn(target), n) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "exists":
+		// exists always uses A records, whatever the client family
+		rrs, err := e.lookup(target, dns.TypeA, true)
+		if err != nil {
+			return false, err
+		}
+		return len(rrs) > 0, nil
+	}
+	return false, permError("unknown mechanism: %v", m.Name)
+}
+
+func (e *SPFEvaluator) matchIPs(ips []net.IP, m SPFTerm) bool {
+	length, bits := m.CIDR4, 32
+	if e.ip.To4() == nil {
+		length, bits = m.CIDR6, 128
+	}
+	if length < 0 {
+		length = bits
+	}
+	mask := net.CIDRMask(length, bits)
+	for _, ip := range ips {
+		if (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).Contains(e.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatedNames returns the names pointing back to the address of the
+// client (RFC 7208 section 5.5)
+func (e *SPFEvaluator) validatedNames() ([]string, error) {
+	rev, err := dns.ReverseAddr(e.ip.String())
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := e.lookup(rev, dns.TypePTR, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i, rr := range rrs {
+		if i == spfNameLimit {
+			break
+		}
+		name := rr.(*dns.PTR).Ptr
+		ips, err := e.lookupIPs(name, false)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(e.ip) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// expandDomain expands the macros of a domain-spec and shortens the result
+// to a valid domain name (RFC 7208 section 7.3)
+func (e *SPFEvaluator) expandDomain(spec, domain string) (string, error) {
+	s, err := e.expand(spec, domain, false)
+	if err != nil {
+		return "", err
+	}
+	s = strings.TrimSuffix(s, ".")
+	for len(s) > 253 {
+		i := strings.IndexByte(s, '.')
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+	}
+	return s, nil
+}
+
+// ExpandExplanation expands the macros of the explanation string found in
+// the TXT record pointed by exp=
+func (e *SPFEvaluator) ExpandExplanation(exp, domain string) (string, error) {
+	return e.expand(exp, domain, true)
+}
+
+// validateMacro checks the syntax of a domain-spec
+func validateMacro(spec string) error {
+	e := &SPFEvaluator{ip: net.IPv4zero}
+	_, err := e.expand(spec, "example.com", false)
+	return err
+}
+
+// expand replaces the macros of RFC 7208 section 7 in spec
+func (e *SPFEvaluator) expand(spec, domain string, exp bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 == len(spec) {
+			return "", permError("invalid macro at the end of %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", permError("unterminated macro in %q", spec)
+			}
+			s, err := e.expandMacro(spec[i+1:i+end], domain, exp)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+			i += end
+		default:
+			return "", permError("invalid macro %%%c in %q", spec[i], spec)
+		}
+	}
+	return b.String(), nil
+}
+
+func (e *SPFEvaluator) expandMacro(body, domain string, exp bool) (string, error) {
+	if body == "" {
+		return "", permError("empty macro")
+	}
+	letter := body[0]
+	local, senderDomain := "postmaster", domain
+	if at := strings.LastIndexByte(e.sender, '@'); at >= 0 {
+		if at > 0 {
+			local = e.sender[:at]
+		}
+		senderDomain = e.sender[at+1:]
+	}
+
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = local + "@" + senderDomain
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		value = macroIP(e.ip)
+	case 'p':
+		// the validated name needs the deprecated PTR lookups, "unknown"
+		// is allowed by RFC 7208 section 7.3
+		value = "unknown"
+	case 'v':
+		value = "in-addr"
+		if e.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'h':
+		value = e.helo
+	case 'c', 'r', 't':
+		if !exp {
+			return "", permError("macro %%{%c} is only allowed in explanations", letter)
+		}
+		switch letter | 0x20 {
+		case 'c':
+			value = e.ip.String()
+		case 'r':
+			value = "unknown"
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", permError("unknown macro letter %c", letter)
+	}
+
+	// transformers: the number of parts to keep, reversal and delimiters
+	rest := body[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", permError("invalid macro transformer in %%{%v}", body)
+		}
+	}
+	rest = rest[digits:]
+	reverse := false
+	if rest != "" && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse, rest = true, rest[1:]
+	}
+	delims := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", permError("invalid macro delimiters in %%{%v}", body)
+		}
+		delims = rest
+	}
+
+	if digits > 0 || reverse || delims != "." {
+		parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delims, r) })
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+
+	if letter >= 'A' && letter <= 'Z' {
+		value = urlEscape(value)
+	}
+	return value, nil
+}
+
+// macroIP formats the address for the i macro, IPv6 addresses are written
+// as dot-separated nibbles
+func macroIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	var nibbles []string
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, fmt.Sprintf("%x", b>>4), fmt.Sprintf("%x", b&0xf))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+func urlEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
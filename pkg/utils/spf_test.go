@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseSPF(t *testing.T) {
+	tests := []struct {
+		record     string
+		mechanisms string
+		redirect   string
+		exp        string
+		err        bool
+	}{
+		{record: "v=spf1 ip4:192.0.2.0/24 -all", mechanisms: "ip4:192.0.2.0/24 -all"},
+		{record: "V=SPF1 IP6:2001:db8::/32 ~ALL", mechanisms: "ip6:2001:db8::/32 ~all"},
+		{record: "v=spf1 a mx/24//64 ptr ?all", mechanisms: "a mx/24//64 ptr ?all"},
+		{
+			record:     "v=spf1 a:mail.example.com/28 -include:_spf.example.net exists:%{i}.x.example.com -all",
+			mechanisms: "a:mail.example.com/28 -include:_spf.example.net exists:%{i}.x.example.com -all",
+		},
+		{record: "v=spf1 redirect=_spf.example.com", redirect: "_spf.example.com"},
+		{record: "v=spf1 -all exp=explain._spf.%{d}", mechanisms: "-all", exp: "explain._spf.%{d}"},
+		{record: "v=spf1 foo=bar -all", mechanisms: "-all"},
+		{record: "v=spf1", mechanisms: ""},
+		{record: "spf2.0/pra -all", err: true},
+		{record: "v=spf1 redirect=a.example.com redirect=b.example.com", err: true},
+		{record: "v=spf1 exp=a.example.com exp=b.example.com", err: true},
+		{record: "v=spf1 redirect=%{x}.example.com", err: true},
+		{record: "v=spf1 ip4:300.0.0.1 -all", err: true},
+		{record: "v=spf1 ip4:192.0.2.0/33 -all", err: true},
+		{record: "v=spf1 ip6:192.0.2.1 -all", err: true},
+		{record: "v=spf1 mx/024 -all", err: true},
+		{record: "v=spf1 all:example.com", err: true},
+		{record: "v=spf1 include -all", err: true},
+		{record: "v=spf1 foo -all", err: true},
+		{record: "v=spf1 exists:%{i -all", err: true},
+	}
+
+	for _, tt := range tests {
+		spf, err := ParseSPF(tt.record)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSPF(%q): no error", tt.record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSPF(%q): %v", tt.record, err)
+			continue
+		}
+		var mechanisms []string
+		for _, m := range spf.Mechanisms {
+			mechanisms = append(mechanisms, m.String())
+		}
+		if got := strings.Join(mechanisms, " "); got != tt.mechanisms {
+			t.Errorf("ParseSPF(%q): mechanisms = %q, want %q", tt.record, got, tt.mechanisms)
+		}
+		if spf.Redirect != tt.redirect || spf.Exp != tt.exp {
+			t.Errorf("ParseSPF(%q): redirect = %q, exp = %q, want %q and %q", tt.record, spf.Redirect, spf.Exp, tt.redirect, tt.exp)
+		}
+	}
+}
+
+func TestSelectSPF(t *testing.T) {
+	tests := []struct {
+		txt    []string
+		record string
+		err    bool
+	}{
+		{txt: []string{"google-site-verification=x", "v=spf1 -all"}, record: "v=spf1 -all"},
+		{txt: []string{"v=spf10 -all", "v=spf1x"}, record: ""},
+		{txt: nil, record: ""},
+		{txt: []string{"v=spf1 -all", "v=spf1 ~all"}, err: true},
+	}
+
+	for _, tt := range tests {
+		var rrs []dns.RR
+		for _, s := range tt.txt {
+			rrs = append(rrs, &dns.TXT{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT}, Txt: []string{s}})
+		}
+		record, err := SelectSPF(rrs)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "2 SPF records") {
+				t.Errorf("SelectSPF(%q): err = %v, want duplicate records", tt.txt, err)
+			}
+			continue
+		}
+		if err != nil || record != tt.record {
+			t.Errorf("SelectSPF(%q) = %q, %v, want %q", tt.txt, record, err, tt.record)
+		}
+	}
+}
+
+// TestExpandMacro uses the examples of RFC 7208 section 7.4
+func TestExpandMacro(t *testing.T) {
+	v4 := NewSPFEvaluator(nil, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com", "mx.example.org")
+	v6 := NewSPFEvaluator(nil, net.ParseIP("2001:db8::cb01"), "strong-bad@email.example.com", "mx.example.org")
+
+	tests := []struct {
+		e    *SPFEvaluator
+		spec string
+		want string
+		exp  bool
+		err  bool
+	}{
+		{e: v4, spec: "%{s}", want: "strong-bad@email.example.com"},
+		{e: v4, spec: "%{o}", want: "email.example.com"},
+		{e: v4, spec: "%{d}", want: "email.example.com"},
+		{e: v4, spec: "%{d4}", want: "email.example.com"},
+		{e: v4, spec: "%{d3}", want: "email.example.com"},
+		{e: v4, spec: "%{d2}", want: "example.com"},
+		{e: v4, spec: "%{d1}", want: "com"},
+		{e: v4, spec: "%{dr}", want: "com.example.email"},
+		{e: v4, spec: "%{d2r}", want: "example.email"},
+		{e: v4, spec: "%{l}", want: "strong-bad"},
+		{e: v4, spec: "%{l-}", want: "strong.bad"},
+		{e: v4, spec: "%{lr}", want: "strong-bad"},
+		{e: v4, spec: "%{lr-}", want: "bad.strong"},
+		{e: v4, spec: "%{l1r-}", want: "strong"},
+		{e: v4, spec: "%{ir}.%{v}._spf.%{d2}", want: "3.2.0.192.in-addr._spf.example.com"},
+		{e: v4, spec: "%{lr-}.lp._spf.%{d2}", want: "bad.strong.lp._spf.example.com"},
+		{e: v4, spec: "%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", want: "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{e: v4, spec: "%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", want: "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{e: v4, spec: "%{d2}.trusted-domains.example.net", want: "example.com.trusted-domains.example.net"},
+		{
+			e:    v6,
+			spec: "%{ir}.%{v}._spf.%{d2}",
+			want: "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com",
+		},
+		{e: v4, spec: "%%%_%-", want: "% %20"},
+		{e: v4, spec: "%{h}", want: "mx.example.org"},
+		{e: v4, spec: "%{S}", want: "strong-bad%40email.example.com"},
+		{e: v4, spec: "%{i} is not one of %{d}'s designated mail servers.", exp: true, want: "192.0.2.3 is not one of email.example.com's designated mail servers."},
+		{e: v4, spec: "%{c}", exp: true, want: "192.0.2.3"},
+		{e: v4, spec: "%{c}", err: true},
+		{e: v4, spec: "%{d0}", err: true},
+		{e: v4, spec: "%{x}", err: true},
+		{e: v4, spec: "%{d", err: true},
+		{e: v4, spec: "%a", err: true},
+		{e: v4, spec: "example.com%", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.e.expand(tt.spec, "email.example.com", tt.exp)
+		if tt.err {
+			if err == nil {
+				t.Errorf("expand(%q) = %q, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+// lookupZone publishes a record with n a mechanisms, named h1 to hn, and
+// the hosts they refer to when exist is set
+func lookupZone(domain string, n int, exist bool) string {
+	var b strings.Builder
+	mechanisms := []string{"v=spf1"}
+	for i := 1; i <= n; i++ {
+		host := fmt.Sprintf("h%d.example.com", i)
+		mechanisms = append(mechanisms, "a:"+host)
+		if exist {
+			fmt.Fprintf(&b, "%v. 300 IN A 203.0.113.%d\n", host, i)
+		}
+	}
+	mechanisms = append(mechanisms, "-all")
+	fmt.Fprintf(&b, "%v. 300 IN TXT %q\n", domain, strings.Join(mechanisms, " "))
+	return b.String()
+}
+
+func TestCheckHost(t *testing.T) {
+	zone := `
+example.com. 300 IN TXT "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net -all"
+_spf.example.net. 300 IN TXT "v=spf1 ip4:198.51.100.0/24 ~all"
+redirect.example.com. 300 IN TXT "v=spf1 redirect=_spf.example.net"
+badredirect.example.com. 300 IN TXT "v=spf1 redirect=nospf.example.com"
+badinclude.example.com. 300 IN TXT "v=spf1 include:nospf.example.com -all"
+nospf.example.com. 300 IN TXT "hello"
+dup.example.com. 300 IN TXT "v=spf1 -all"
+dup.example.com. 300 IN TXT "v=spf1 +all"
+mx.example.com. 300 IN TXT "v=spf1 mx/24 -all"
+mx.example.com. 300 IN MX 10 mail.example.com.
+mail.example.com. 300 IN A 203.0.113.200
+void1.example.com. 300 IN TXT "v=spf1 a:nx1.example.com a:nx2.example.com -all"
+void2.example.com. 300 IN TXT "v=spf1 a:nx1.example.com a:nx2.example.com a:nx3.example.com -all"
+` + lookupZone("ten.example.com", 10, true) + lookupZone("eleven.example.com", 11, true)
+	r := fakeResolver(t, zone)
+
+	tests := []struct {
+		domain  string
+		ip      string
+		result  string
+		lookups int
+		finding string
+	}{
+		{domain: "example.com", ip: "192.0.2.10", result: SPFPass},
+		{domain: "example.com", ip: "198.51.100.1", result: SPFPass, lookups: 1},
+		{domain: "example.com", ip: "203.0.113.1", result: SPFFail, lookups: 1},
+		{domain: "redirect.example.com", ip: "198.51.100.1", result: SPFPass, lookups: 1},
+		{domain: "redirect.example.com", ip: "203.0.113.1", result: SPFSoftFail, lookups: 1, finding: "~all only soft-fails"},
+		{domain: "badredirect.example.com", ip: "203.0.113.1", result: SPFPermError, lookups: 1, finding: "which has no SPF record"},
+		{domain: "badinclude.example.com", ip: "203.0.113.1", result: SPFPermError, lookups: 1, finding: "include:nospf.example.com has no SPF record"},
+		{domain: "dup.example.com", ip: "203.0.113.1", result: SPFPermError, finding: "2 SPF records published"},
+		{domain: "mx.example.com", ip: "203.0.113.7", result: SPFPass, lookups: 1},
+		{domain: "void1.example.com", ip: "203.0.113.1", result: SPFFail, lookups: 2},
+		{domain: "void2.example.com", ip: "203.0.113.1", result: SPFPermError, lookups: 3, finding: "void lookups"},
+		{domain: "ten.example.com", ip: "192.0.2.1", result: SPFFail, lookups: 10},
+		{domain: "eleven.example.com", ip: "192.0.2.1", result: SPFPermError, lookups: 11, finding: "more than 10 DNS lookups"},
+		{domain: "nospf.example.com", ip: "192.0.2.1", result: SPFNone},
+	}
+
+	for _, tt := range tests {
+		e := NewSPFEvaluator(r, net.ParseIP(tt.ip), "postmaster@"+tt.domain, "mx.example.org")
+		result, _ := e.CheckHost(tt.domain)
+		if result != tt.result {
+			t.Errorf("CheckHost(%v) from %v = %v, want %v (trace %q)", tt.domain, tt.ip, result, tt.result, e.Trace)
+		}
+		if e.Lookups != tt.lookups {
+			t.Errorf("CheckHost(%v) from %v: %d lookups, want %d", tt.domain, tt.ip, e.Lookups, tt.lookups)
+		}
+		if tt.finding != "" && !strings.Contains(strings.Join(e.Findings, "\n"), tt.finding) {
+			t.Errorf("CheckHost(%v) from %v: findings %q, want %q", tt.domain, tt.ip, e.Findings, tt.finding)
+		}
+	}
+}
+
+func TestFlattenSPF(t *testing.T) {
+	r := fakeResolver(t, `
+_spf.example.net. 300 IN TXT "v=spf1 ip4:198.51.100.0/24 ip6:2001:db8::/126 a:host.example.net -all"
+host.example.net. 300 IN A 203.0.113.5
+`)
+	flat := FlattenSPF(r, "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net exists:%{i}.x.example.com -all", "example.com")
+
+	var prefixes []string
+	for _, p := range flat.Prefixes {
+		prefixes = append(prefixes, p.Prefix.String())
+	}
+	want := "192.0.2.0/24 198.51.100.0/24 2001:db8::/126 203.0.113.5/32"
+	if got := strings.Join(prefixes, " "); got != want {
+		t.Errorf("prefixes = %q, want %q", got, want)
+	}
+	if len(flat.Unresolved) != 1 || !strings.Contains(flat.Unresolved[0], "exists") {
+		t.Errorf("unresolved = %q, want the exists mechanism", flat.Unresolved)
+	}
+	if _, ok := flat.Includes["_spf.example.net"]; !ok {
+		t.Errorf("includes = %v, want _spf.example.net", flat.Includes)
+	}
+	if via := flat.Via("_spf.example.net"); len(via) != 3 {
+		t.Errorf("Via(_spf.example.net) = %v, want 3 prefixes", via)
+	}
+
+	v4, v6 := CountAddresses(flat.Prefixes)
+	if v4.Int64() != 256+256+1 || v6.Int64() != 4 {
+		t.Errorf("CountAddresses = %v IPv4 and %v IPv6, want 513 and 4", v4, v6)
+	}
+}
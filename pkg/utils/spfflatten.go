@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// spfFlattenDepth bounds the nesting of includes and redirects followed
+// while flattening, loops are stopped earlier by the visited set
+const spfFlattenDepth = 10
+
+// SPFPrefix is a network authorized by an SPF policy, Chain is the list of
+// records and mechanisms that led to it, from the top level domain
+type SPFPrefix struct {
+	Prefix *net.IPNet
+	Chain  []string
+}
+
+func (p SPFPrefix) String() string {
+	return fmt.Sprintf("%v <- %v", p.Prefix, strings.Join(p.Chain, " > "))
+}
+
+// SPFFlattening is the set of networks authorized to send mail by a policy,
+// along with the parts of the policy that can't be flattened
+type SPFFlattening struct {
+	Prefixes []SPFPrefix
+	// Unresolved are the mechanisms depending on the client (ptr, exists
+	// and macros) or whose lookup failed
+	Unresolved []string
+	// Includes maps every included or redirected domain to the chain
+	// that reached it
+	Includes map[string][]string
+}
+
+// FlattenSPF expands the SPF record of domain into the networks it
+// authorizes with a pass result, following every include and redirect
+// without stopping at the first match as an evaluation would
+func FlattenSPF(resolver *Resolver, record, domain string) *SPFFlattening {
+	f := &SPFFlattening{Includes: make(map[string][]string)}
+	visited := map[string]bool{strings.ToLower(domain): true}
+	f.flatten(resolver, record, domain, []string{domain}, visited)
+	return f
+}
+
+func (f *SPFFlattening) flatten(resolver *Resolver, record, domain string, chain []string, visited map[string]bool) {
+	spf, err := ParseSPF(record)
+	if err != nil {
+		f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: %v", strings.Join(chain, " > "), err))
+		return
+	}
+
+	var all bool
+	for _, m := range spf.Mechanisms {
+		all = all || m.Name == "all"
+		// only the mechanisms giving a pass result authorize a sender
+		if m.Qualifier != '+' {
+			continue
+		}
+		step := append(append([]string{}, chain...), m.String())
+
+		if m.Name != "ip4" && m.Name != "ip6" && m.Name != "all" && strings.Contains(m.Value, "%") {
+			f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: depends on the sender (macro)", strings.Join(step, " > ")))
+			continue
+		}
+		target := strings.TrimSuffix(m.Value, ".")
+		if target == "" {
+			target = domain
+		}
+
+		switch m.Name {
+		case "all":
+			_, v4, _ := net.ParseCIDR("0.0.0.0/0")
+			_, v6, _ := net.ParseCIDR("::/0")
+			f.Prefixes = append(f.Prefixes, SPFPrefix{Prefix: v4, Chain: step}, SPFPrefix{Prefix: v6, Chain: step})
+		case "ip4", "ip6":
+			network, err := m.Network()
+			if err != nil {
+				f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: %v", strings.Join(step, " > "), err))
+				continue
+			}
+			f.Prefixes = append(f.Prefixes, SPFPrefix{Prefix: network, Chain: step})
+		case "a":
+			f.addHosts(resolver, []string{target}, m, step)
+		case "mx":
+			r, err := resolver.Query(target, dns.TypeMX)
+			if err != nil {
+				f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: %v", strings.Join(step, " > "), err))
+				continue
+			}
+			var hosts []string
+			for _, rr := range r.Answer {
+				if mx, ok := rr.(*dns.MX); ok {
+					hosts = append(hosts, mx.Mx)
+				}
+			}
+			f.addHosts(resolver, hosts, m, step)
+		case "include":
+			f.follow(resolver, target, step, visited)
+		default:
+			f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: depends on the sender", strings.Join(step, " > ")))
+		}
+	}
+
+	if spf.Redirect != "" && !all {
+		step := append(append([]string{}, chain...), "redirect="+spf.Redirect)
+		if strings.Contains(spf.Redirect, "%") {
+			f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: depends on the sender (macro)", strings.Join(step, " > ")))
+			return
+		}
+		f.follow(resolver, strings.TrimSuffix(spf.Redirect, "."), step, visited)
+	}
+}
+
+// follow flattens the record of an included or redirected domain
+func (f *SPFFlattening) follow(resolver *Resolver, target string, chain []string, visited map[string]bool) {
+	if visited[strings.ToLower(target)] || len(chain) > spfFlattenDepth {
+		f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: loop or too deep nesting", strings.Join(chain, " > ")))
+		return
+	}
+	visited[strings.ToLower(target)] = true
+	defer delete(visited, strings.ToLower(target))
+	f.Includes[strings.ToLower(target)] = chain
+
+	r, err := resolver.Query(target, dns.TypeTXT)
+	var record string
+	if err == nil {
+		record, err = SelectSPF(r.Answer)
+	}
+	if err == nil && record == "" {
+		err = fmt.Errorf("no SPF record")
+	}
+	if err != nil {
+		f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: %v", strings.Join(chain, " > "), err))
+		return
+	}
+	f.flatten(resolver, record, target, chain, visited)
+}
+
+// addHosts adds the networks of the addresses of hosts, with the prefix
+// lengths of the a or mx mechanism
+func (f *SPFFlattening) addHosts(resolver *Resolver, hosts []string, m SPFTerm, chain []string) {
+	for _, host := range hosts {
+		ips, err := resolver.LookupIPs(host)
+		if err != nil {
+			f.Unresolved = append(f.Unresolved, fmt.Sprintf("%v: %v", strings.Join(chain, " > "), err))
+			continue
+		}
+		for _, ip := range ips {
+			length, bits := m.CIDR4, 32
+			if ip.To4() == nil {
+				length, bits = m.CIDR6, 128
+			} else {
+				ip = ip.To4()
+			}
+			if length < 0 {
+				length = bits
+			}
+			mask := net.CIDRMask(length, bits)
+			f.Prefixes = append(f.Prefixes, SPFPrefix{Prefix: &net.IPNet{IP: ip.Mask(mask), Mask: mask}, Chain: chain})
+		}
+	}
+}
+
+// Via returns the networks authorized through the include or redirect of
+// domain, directly or by nested includes
+func (f *SPFFlattening) Via(domain string) []SPFPrefix {
+	var prefixes []SPFPrefix
+	for _, p := range f.Prefixes {
+		for _, step := range p.Chain {
+			target := strings.TrimPrefix(strings.TrimPrefix(step, "include:"), "redirect=")
+			if target != step && strings.EqualFold(strings.TrimSuffix(target, "."), domain) {
+				prefixes = append(prefixes, p)
+				break
+			}
+		}
+	}
+	return prefixes
+}
+
+// CountAddresses returns the number of IPv4 and IPv6 addresses in the
+// networks, the overlapping ones are counted once
+func CountAddresses(prefixes []SPFPrefix) (*big.Int, *big.Int) {
+	var v4, v6 []*net.IPNet
+	for _, p := range prefixes {
+		if p.Prefix.IP.To4() != nil {
+			v4 = append(v4, p.Prefix)
+		} else {
+			v6 = append(v6, p.Prefix)
+		}
+	}
+	return unionSize(v4), unionSize(v6)
+}
+
+// unionSize returns the number of addresses covered by the networks, all
+// of the same family
+func unionSize(networks []*net.IPNet) *big.Int {
+	type span struct{ start, end *big.Int }
+	var spans []span
+	for _, n := range networks {
+		ones, bits := n.Mask.Size()
+		start := new(big.Int).SetBytes(n.IP)
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		spans = append(spans, span{start, new(big.Int).Add(start, size)})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Cmp(spans[j].start) < 0 })
+
+	total := new(big.Int)
+	var cur *span
+	for i := range spans {
+		s := spans[i]
+		if cur != nil && s.start.Cmp(cur.end) <= 0 {
+			if s.end.Cmp(cur.end) > 0 {
+				cur.end = s.end
+			}
+			continue
+		}
+		if cur != nil {
+			total.Add(total, new(big.Int).Sub(cur.end, cur.start))
+		}
+		cur = &spans[i]
+	}
+	if cur != nil {
+		total.Add(total, new(big.Int).Sub(cur.end, cur.start))
+	}
+	return total
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ROA validation states as defined in RFC 6811
+const (
+	ROAValid    = "Valid"
+	ROAInvalid  = "Invalid"
+	ROANotFound = "NotFound"
+)
+
+// VRP is a Validated ROA Payload: the origin AS that is allowed to announce
+// the prefix, up to the MaxLength prefix length
+type VRP struct {
+	ASN       uint32
+	Prefix    *net.IPNet
+	MaxLength int
+	TA        string
+}
+
+// ROAValidation is the outcome of an origin validation, along with the VRPs
+// that covered the announced prefix
+type ROAValidation struct {
+	State    string
+	Reason   string
+	Covering []VRP
+}
+
+// LoadVRPs reads a VRP set exported by rpki-client or Routinator, both the
+// JSON and the CSV formats are supported
+func LoadVRPs(path string) ([]VRP, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		return parseVRPJSON([]byte(trimmed))
+	}
+	return parseVRPCSV(strings.NewReader(trimmed))
+}
+
+// ValidateOrigin performs route origin validation of the announcement of
+// prefix by asn against the VRP set (RFC 6811 section 2)
+func ValidateOrigin(vrps []VRP, asn uint32, prefix *net.IPNet) *ROAValidation {
+	res := &ROAValidation{State: ROANotFound}
+	length, _ := prefix.Mask.Size()
+
+	for _, v := range vrps {
+		if coversPrefix(v.Prefix, prefix) {
+			res.Covering = append(res.Covering, v)
+		}
+	}
+	if len(res.Covering) == 0 {
+		res.Reason = fmt.Sprintf("no ROA covers %v", prefix)
+		return res
+	}
+
+	var originMatched bool
+	for _, v := range res.Covering {
+		// AS0 ROAs never match an announcement (RFC 6483 section 4)
+		if v.ASN == 0 || v.ASN != asn {
+			continue
+		}
+		originMatched = true
+		if length <= v.MaxLength {
+			res.State = ROAValid
+			res.Reason = fmt.Sprintf("AS%d is authorized by ROA %v maxLength %d", asn, v.Prefix, v.MaxLength)
+			return res
+		}
+	}
+
+	res.State = ROAInvalid
+	if originMatched {
+		res.Reason = fmt.Sprintf("%v is more specific than the maxLength authorized for AS%d", prefix, asn)
+	} else {
+		res.Reason = fmt.Sprintf("AS%d is not authorized to originate %v", asn, prefix)
+	}
+	return res
+}
+
+// ParseASN converts the textual representation of an AS number, with or
+// without the "AS" prefix, to its numeric value
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number: %v", s)
+	}
+	return uint32(n), nil
+}
+
+// coversPrefix tells if outer contains inner, both of the same family
+func coversPrefix(outer, inner *net.IPNet) bool {
+	outerLen, outerBits := outer.Mask.Size()
+	innerLen, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerLen <= innerLen && outer.Contains(inner.IP)
+}
+
+type vrpJSON struct {
+	ASN       json.RawMessage `json:"asn"`
+	Prefix    string          `json:"prefix"`
+	MaxLength int             `json:"maxLength"`
+	TA        string          `json:"ta"`
+}
+
+func parseVRPJSON(data []byte) ([]VRP, error) {
+	var export struct {
+		ROAs []vrpJSON `json:"roas"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	var vrps []VRP
+	for _, r := range export.ROAs {
+		// rpki-client exports the AS number as an integer, Routinator
+		// as a string in the "AS13335" form
+		asn, err := ParseASN(strings.Trim(string(r.ASN), `"`))
+		if err != nil {
+			return nil, err
+		}
+		v, err := newVRP(asn, r.Prefix, r.MaxLength, r.TA)
+		if err != nil {
+			return nil, err
+		}
+		vrps = append(vrps, *v)
+	}
+	return vrps, nil
+}
+
+func parseVRPCSV(r io.Reader) ([]VRP, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var vrps []VRP
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("invalid VRP line: %v", strings.Join(record, ","))
+		}
+		// skip the "ASN,IP Prefix,Max Length,Trust Anchor" header
+		if strings.EqualFold(record[0], "ASN") {
+			continue
+		}
+
+		asn, err := ParseASN(record[0])
+		if err != nil {
+			return nil, err
+		}
+		maxLength, err := strconv.Atoi(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid max length: %v", record[2])
+		}
+		var ta string
+		if len(record) > 3 {
+			ta = record[3]
+		}
+		v, err := newVRP(asn, record[1], maxLength, ta)
+		if err != nil {
+			return nil, err
+		}
+		vrps = append(vrps, *v)
+	}
+	return vrps, nil
+}
+
+func newVRP(asn uint32, prefix string, maxLength int, ta string) (*VRP, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	length, bits := network.Mask.Size()
+	if maxLength == 0 {
+		maxLength = length
+	}
+	// the maxLength can't be shorter than the prefix (RFC 6482 section 3.3)
+	if maxLength < length || maxLength > bits {
+		return nil, fmt.Errorf("invalid max length %d for %v", maxLength, network)
+	}
+	return &VRP{ASN: asn, Prefix: network, MaxLength: maxLength, TA: ta}, nil
+}
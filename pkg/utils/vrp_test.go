@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeVRPs(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadVRPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		vrps    []string
+		err     bool
+	}{
+		{
+			name: "rpki-client.json",
+			content: `{"metadata": {}, "roas": [
+				{"asn": 13335, "prefix": "1.1.1.0/24", "maxLength": 24, "ta": "apnic"},
+				{"asn": 64496, "prefix": "2001:db8::/32", "maxLength": 48, "ta": "ripe"}
+			]}`,
+			vrps: []string{"AS13335 1.1.1.0/24-24 apnic", "AS64496 2001:db8::/32-48 ripe"},
+		},
+		{
+			name:    "routinator.json",
+			content: `{"roas": [{"asn": "AS13335", "prefix": "1.0.0.0/24", "maxLength": 0, "ta": "apnic"}]}`,
+			vrps:    []string{"AS13335 1.0.0.0/24-24 apnic"},
+		},
+		{
+			name:    "routinator.csv",
+			content: "ASN,IP Prefix,Max Length,Trust Anchor\nAS13335,1.1.1.0/24,24,apnic\nAS0, 192.0.2.0/24, 32, arin\n",
+			vrps:    []string{"AS13335 1.1.1.0/24-24 apnic", "AS0 192.0.2.0/24-32 arin"},
+		},
+		{
+			name:    "short.csv",
+			content: "64496,198.51.100.0/22,23\n",
+			vrps:    []string{"AS64496 198.51.100.0/22-23 "},
+		},
+		{name: "shorter-max.csv", content: "AS64496,198.51.100.0/22,21\n", err: true},
+		{name: "longer-max.csv", content: "AS64496,198.51.100.0/22,33\n", err: true},
+		{name: "bad-max.csv", content: "AS64496,198.51.100.0/22,x\n", err: true},
+		{name: "bad-asn.csv", content: "ASX,198.51.100.0/22,22\n", err: true},
+		{name: "bad-prefix.csv", content: "AS64496,198.51.100.0,22\n", err: true},
+		{name: "missing.csv", content: "AS64496,198.51.100.0/22\n", err: true},
+		{name: "bad.json", content: `{"roas": [{"asn": "ASX", "prefix": "1.1.1.0/24", "maxLength": 24}]}`, err: true},
+	}
+
+	for _, tt := range tests {
+		vrps, err := LoadVRPs(writeVRPs(t, tt.name, tt.content))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if len(vrps) != len(tt.vrps) {
+			t.Errorf("%v: %d VRPs, want %d", tt.name, len(vrps), len(tt.vrps))
+			continue
+		}
+		for i, v := range vrps {
+			got := fmt.Sprintf("AS%d %v-%d %v", v.ASN, v.Prefix, v.MaxLength, v.TA)
+			if got != tt.vrps[i] {
+				t.Errorf("%v: VRP %d = %q, want %q", tt.name, i, got, tt.vrps[i])
+			}
+		}
+	}
+
+	if _, err := LoadVRPs(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestValidateOrigin(t *testing.T) {
+	vrps, err := parseVRPCSV(strings.NewReader(`AS64496,192.0.2.0/24,24,ta
+AS64497,198.51.100.0/22,24,ta
+AS0,203.0.113.0/24,24,ta
+AS64498,2001:db8::/32,48,ta
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix   string
+		asn      uint32
+		state    string
+		covering int
+	}{
+		{prefix: "192.0.2.0/24", asn: 64496, state: ROAValid, covering: 1},
+		{prefix: "192.0.2.0/24", asn: 64499, state: ROAInvalid, covering: 1},
+		{prefix: "192.0.2.128/25", asn: 64496, state: ROAInvalid, covering: 1},
+		{prefix: "198.51.100.0/23", asn: 64497, state: ROAValid, covering: 1},
+		{prefix: "198.51.101.0/24", asn: 64497, state: ROAValid, covering: 1},
+		{prefix: "198.51.101.0/25", asn: 64497, state: ROAInvalid, covering: 1},
+		{prefix: "198.51.0.0/16", asn: 64497, state: ROANotFound},
+		{prefix: "203.0.113.0/24", asn: 64496, state: ROAInvalid, covering: 1},
+		{prefix: "203.0.113.0/24", asn: 0, state: ROAInvalid, covering: 1},
+		{prefix: "2001:db8:1::/48", asn: 64498, state: ROAValid, covering: 1},
+		{prefix: "2001:db8:1::/64", asn: 64498, state: ROAInvalid, covering: 1},
+		{prefix: "2001:db9::/32", asn: 64498, state: ROANotFound},
+		{prefix: "10.0.0.0/8", asn: 64496, state: ROANotFound},
+	}
+
+	for _, tt := range tests {
+		_, prefix, _ := net.ParseCIDR(tt.prefix)
+		v := ValidateOrigin(vrps, tt.asn, prefix)
+		if v.State != tt.state || len(v.Covering) != tt.covering {
+			t.Errorf("ValidateOrigin(AS%d, %v) = %v with %d covering VRPs (%v), want %v with %d",
+				tt.asn, tt.prefix, v.State, len(v.Covering), v.Reason, tt.state, tt.covering)
+		}
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		s   string
+		asn uint32
+		err bool
+	}{
+		{s: "13335", asn: 13335},
+		{s: "AS13335", asn: 13335},
+		{s: " as64496 ", asn: 64496},
+		{s: "AS4294967295", asn: 4294967295},
+		{s: "AS0", asn: 0},
+		{s: "AS4294967296", err: true},
+		{s: "AS-1", err: true},
+		{s: "ASN13335", err: true},
+		{s: "", err: true},
+	}
+
+	for _, tt := range tests {
+		asn, err := ParseASN(tt.s)
+		if (err != nil) != tt.err || asn != tt.asn {
+			t.Errorf("ParseASN(%q) = %d, %v", tt.s, asn, err)
+		}
+	}
+}